@@ -0,0 +1,96 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+const activityChSize = 100
+
+// activityRecord is a JSON line of the AuditWriter, describing one PutLogEvents attempt.
+type activityRecord struct {
+	Time      time.Time `json:"time"`
+	Group     string    `json:"group"`
+	Stream    string    `json:"stream"`
+	Action    string    `json:"action"`
+	Outcome   string    `json:"outcome"` // success or error
+	Events    int       `json:"events"`
+	Bytes     int       `json:"bytes"`
+	Rejected  int       `json:"rejected,omitempty"`
+	Retry     int       `json:"retry"`
+	LatencyMs int64     `json:"latency_ms"`
+	ErrorCode string    `json:"error_code,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// recordAttempt queues the record of a PutLogEvents attempt for the AuditWriter, dropping it when the queue is full.
+func (p *pusher) recordAttempt(events []*cloudwatchlogs.InputLogEvent, size, retry int, output *cloudwatchlogs.PutLogEventsOutput, err error, latency time.Duration) {
+	if p.AuditWriter == nil {
+		return
+	}
+	p.activityOnce.Do(func() {
+		p.activityCh = make(chan activityRecord, activityChSize)
+		go p.writeActivity()
+	})
+
+	r := activityRecord{
+		Time:      time.Now(),
+		Group:     p.Group,
+		Stream:    p.Stream,
+		Action:    "PutLogEvents",
+		Outcome:   "success",
+		Events:    len(events),
+		Bytes:     size,
+		Retry:     retry,
+		LatencyMs: int64(latency / time.Millisecond),
+	}
+	if err != nil {
+		r.Outcome = "error"
+		r.Error = err.Error()
+		if awsErr, ok := err.(awserr.Error); ok {
+			r.ErrorCode = awsErr.Code()
+		}
+	} else if output.RejectedLogEventsInfo != nil {
+		for _, reason := range rejectReasons(output.RejectedLogEventsInfo, len(events)) {
+			if reason != notRejected {
+				r.Rejected++
+			}
+		}
+	}
+	select {
+	case p.activityCh <- r:
+	default:
+		p.Log.Warnf("Audit log of %v/%v is falling behind, a record was dropped", p.Group, p.Stream)
+	}
+}
+
+// writeActivity writes the queued records until the pusher is done, then the remaining ones.
+func (p *pusher) writeActivity() {
+	enc := json.NewEncoder(p.AuditWriter)
+	write := func(r activityRecord) {
+		if err := enc.Encode(r); err != nil {
+			p.Log.Warnf("Unable to write the audit log of %v: %v", p.targetName(), err)
+		}
+	}
+	for {
+		select {
+		case r := <-p.activityCh:
+			write(r)
+		case <-p.done:
+			for {
+				select {
+				case r := <-p.activityCh:
+					write(r)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// ArchiveSink is a destination of the log events next to CloudWatch Logs, such as an archive in S3, fed
+// by the batches of the pusher according to SinkStrategy. Archive is called from the send loop, and
+// returns once the events are stored durably. It must be fast, as it delays the sends.
+type ArchiveSink interface {
+	Archive(group, stream string, events []*cloudwatchlogs.InputLogEvent) error
+}
+
+// SinkStrategy is how the batches of the pusher feed CloudWatch Logs and the ArchiveSink.
+type SinkStrategy int
+
+const (
+	// CloudWatchOnly sends the events to CloudWatch Logs only.
+	CloudWatchOnly SinkStrategy = iota
+	// Tee sends every batch to both CloudWatch Logs and the ArchiveSink, as a full archive.
+	Tee
+	// CloudWatchWithS3Overflow sends the events to CloudWatch Logs, and only the ones it does not take to
+	// the ArchiveSink: the events dead-lettered, rejected, or dropped over the byte budget or the pause buffer.
+	CloudWatchWithS3Overflow
+)
+
+func (s SinkStrategy) String() string {
+	switch s {
+	case CloudWatchOnly:
+		return "CloudWatchOnly"
+	case Tee:
+		return "Tee"
+	case CloudWatchWithS3Overflow:
+		return "CloudWatchWithS3Overflow"
+	}
+	return fmt.Sprintf("SinkStrategy(%d)", int(s))
+}
+
+// TeeDurability is when the done callbacks of the events of a batch are called with the Tee strategy.
+type TeeDurability int
+
+const (
+	// DoneAfterBoth calls them once both CloudWatch Logs and the ArchiveSink stored the batch. They are
+	// not called when the archive fails, so the source may send the events again.
+	DoneAfterBoth TeeDurability = iota
+	// DoneAfterCloudWatch calls them once CloudWatch Logs accepted the batch, whether it was archived or not.
+	DoneAfterCloudWatch
+)
+
+func (d TeeDurability) String() string {
+	switch d {
+	case DoneAfterBoth:
+		return "DoneAfterBoth"
+	case DoneAfterCloudWatch:
+		return "DoneAfterCloudWatch"
+	}
+	return fmt.Sprintf("TeeDurability(%d)", int(d))
+}
+
+// skipDone replaces the done callbacks of the events not stored by both sinks.
+func skipDone() {}
+
+// teeBatch archives a batch about to be sent with the Tee strategy, once per batch whatever its retries.
+// The done callbacks of the batch are skipped when the archive fails under DoneAfterBoth.
+func (p *pusher) teeBatch(b *logBatch) {
+	if p.SinkStrategy != Tee || p.ArchiveSink == nil {
+		return
+	}
+	if p.archive(b.events) || p.TeeDurability != DoneAfterBoth {
+		return
+	}
+	// b.dones may be the buffer of the next batch, it is replaced rather than modified
+	dones := make([]func(), len(b.dones))
+	for i := range dones {
+		dones[i] = skipDone
+	}
+	b.dones = dones
+}
+
+// archiveOverflow archives the events CloudWatch Logs did not take with the CloudWatchWithS3Overflow strategy.
+func (p *pusher) archiveOverflow(events []*cloudwatchlogs.InputLogEvent) {
+	if p.SinkStrategy != CloudWatchWithS3Overflow || p.ArchiveSink == nil || len(events) == 0 {
+		return
+	}
+	p.archive(events)
+}
+
+// archive passes the events to the ArchiveSink and counts them in Stats, and returns whether it succeeded.
+func (p *pusher) archive(events []*cloudwatchlogs.InputLogEvent) bool {
+	if err := p.ArchiveSink.Archive(p.Group, p.Stream, events); err != nil {
+		p.Log.Errorf("Unable to archive %v log events of %v/%v: %v", len(events), p.Group, p.Stream, err)
+		p.reportError(err)
+		return false
+	}
+	atomic.AddInt64(&p.counters.eventsArchived, int64(len(events)))
+	return true
+}
@@ -0,0 +1,61 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// requestSize is the size of the events as CloudWatch Logs counts it against the request size limit:
+// the UTF-8 size of each message, once each invalid byte is replaced by U+FFFD when serialized, plus
+// the documented event header size, whatever EventHeaderSize.
+func requestSize(events []*cloudwatchlogs.InputLogEvent) int {
+	size := 0
+	for _, e := range events {
+		size += messageSize(*e.Message) + eventHeaderSize
+	}
+	return size
+}
+
+func messageSize(message string) int {
+	if utf8.ValidString(message) {
+		return len(message)
+	}
+	size := 0
+	for i := 0; i < len(message); {
+		r, n := utf8.DecodeRuneInString(message[i:])
+		if r == utf8.RuneError && n == 1 {
+			size += utf8.RuneLen(utf8.RuneError)
+		} else {
+			size += n
+		}
+		i += n
+	}
+	return size
+}
+
+// splitOversizedBatch splits a batch over the request size limit, which the size estimated as the events
+// are added missed, into halves small enough to be sent. It returns the batch alone when it fits or has
+// a single event.
+func (p *pusher) splitOversizedBatch(b *logBatch) []*logBatch {
+	if len(b.events) < 2 || requestSize(b.events) <= reqSizeLimit {
+		return []*logBatch{b}
+	}
+	mid := len(b.events) / 2
+	p.Log.Warnf("Batch of %v log events for %v/%v is over the request size limit, sending it in two requests", len(b.events), p.Group, p.Stream)
+	halves := []*logBatch{
+		{events: b.events[:mid], dones: b.dones[:mid], startTime: b.startTime, deadline: p.earliestDeadline(b.events[:mid])},
+		{events: b.events[mid:], dones: b.dones[mid:], startTime: b.startTime, deadline: p.earliestDeadline(b.events[mid:])},
+	}
+	var batches []*logBatch
+	for _, h := range halves {
+		for _, e := range h.events {
+			h.size += len(*e.Message) + p.eventHeaderSize()
+		}
+		batches = append(batches, p.splitOversizedBatch(h)...)
+	}
+	return batches
+}
@@ -0,0 +1,36 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"time"
+)
+
+// startBatchWait starts the MaxBatchWait timer of a new batch.
+func (p *pusher) startBatchWait() {
+	if p.MaxBatchWait <= 0 {
+		return
+	}
+	p.batchWaitTimer.Stop()
+	p.batchWaitTimer.Reset(p.MaxBatchWait)
+}
+
+// batchWaitExceeded reports whether the first event of the batch was added MaxBatchWait ago or more.
+func (p *pusher) batchWaitExceeded() bool {
+	return p.MaxBatchWait > 0 && len(p.events) > 0 && time.Since(p.batchStart) >= p.MaxBatchWait
+}
+
+// batchWaitExpired sends the batch once it waited MaxBatchWait, whatever the flush timer. The timer may
+// expire for a batch already sent, it is then rearmed for the current batch.
+func (p *pusher) batchWaitExpired() {
+	if len(p.events) == 0 {
+		return
+	}
+	if p.batchWaitExceeded() {
+		p.Log.Debugf("Batch of %v/%v waited %v, sending %v log events", p.Group, p.Stream, p.MaxBatchWait, len(p.events))
+		p.send()
+		return
+	}
+	p.batchWaitTimer.Reset(p.MaxBatchWait - time.Since(p.batchStart))
+}
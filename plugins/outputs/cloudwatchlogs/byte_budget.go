@@ -0,0 +1,117 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+const defaultByteBudgetWindow = time.Minute
+
+var errByteBudgetExceeded = errors.New("byte budget exceeded")
+
+// applyByteBudget returns whether the batch can be sent within the byte budget of the current window.
+// Over the budget, the batch is either dropped or held until the window rolls, on the budget timer of the
+// event loop, behind the batches already held so they are still sent in order. A batch larger than the
+// whole budget is only sent at the start of a window. The budget is charged once the events are delivered.
+func (p *pusher) applyByteBudget(b *logBatch) bool {
+	if p.ByteBudgetPerWindow <= 0 || (len(p.budgetHeld) == 0 && p.withinByteBudget(b)) {
+		return true
+	}
+
+	window := p.byteBudgetWindow()
+	if p.DropOverBudget {
+		p.Log.Warnf("Byte budget of %v bytes per %v exceeded for %v/%v, dropping %v log events.", p.ByteBudgetPerWindow, window, p.Group, p.Stream, len(b.events))
+		p.deadLetter(b.events, errByteBudgetExceeded)
+		return false
+	}
+
+	p.Log.Debugf("Byte budget of %v bytes per %v exceeded for %v/%v, holding %v log events until the next window.", p.ByteBudgetPerWindow, window, p.Group, p.Stream, len(b.events))
+	// The batch may outlive the buffers, which are reused after reset
+	b.events = append([]*cloudwatchlogs.InputLogEvent(nil), b.events...)
+	b.dones = append(make([]func(), 0, len(b.dones)), b.dones...)
+	p.budgetHeld = append(p.budgetHeld, b)
+	p.resetBudgetTimer()
+	return false
+}
+
+// withinByteBudget returns whether the batch fits in what is left of the budget of the current window.
+func (p *pusher) withinByteBudget(b *logBatch) bool {
+	p.rollByteBudgetWindow()
+	used := atomic.LoadInt64(&p.counters.budgetUsed)
+	return used == 0 || used+int64(b.size) <= int64(p.ByteBudgetPerWindow)
+}
+
+// chargeByteBudget accounts the bytes delivered against the budget of the current window.
+func (p *pusher) chargeByteBudget(size int) {
+	if p.ByteBudgetPerWindow <= 0 {
+		return
+	}
+	p.rollByteBudgetWindow()
+	atomic.AddInt64(&p.counters.budgetUsed, int64(size))
+}
+
+// rollByteBudgetWindow starts a new window once the current one is over, and returns when it started.
+func (p *pusher) rollByteBudgetWindow() time.Time {
+	c := p.counters
+	now := time.Now()
+	start := time.Unix(0, atomic.LoadInt64(&c.budgetWindowStart))
+	if now.Sub(start) >= p.byteBudgetWindow() {
+		start = now
+		atomic.StoreInt64(&c.budgetWindowStart, now.UnixNano())
+		atomic.StoreInt64(&c.budgetUsed, 0)
+	}
+	return start
+}
+
+// sendBudgetHeld sends the held batches, in order, while they fit in the budget of the window, or all of
+// them when force is set, on stop.
+func (p *pusher) sendBudgetHeld(force bool) {
+	for len(p.budgetHeld) > 0 {
+		b := p.budgetHeld[0]
+		if !force && !p.withinByteBudget(b) {
+			break
+		}
+		p.budgetHeld[0] = nil
+		p.budgetHeld = p.budgetHeld[1:]
+		p.sendBatch(b)
+	}
+	p.resetBudgetTimer()
+}
+
+func (p *pusher) resetBudgetTimer() {
+	p.budgetTimer.Stop()
+	if len(p.budgetHeld) > 0 {
+		start := p.rollByteBudgetWindow()
+		p.budgetTimer.Reset(time.Until(start.Add(p.byteBudgetWindow())))
+	}
+}
+
+func (p *pusher) byteBudgetWindow() time.Duration {
+	if p.ByteBudgetWindow <= 0 {
+		return defaultByteBudgetWindow
+	}
+	return p.ByteBudgetWindow
+}
+
+// byteBudgetRemaining returns the bytes left in the budget of the current window, 0 without a budget.
+func (p *pusher) byteBudgetRemaining() int64 {
+	if p.ByteBudgetPerWindow <= 0 {
+		return 0
+	}
+	c := p.counters
+	start := time.Unix(0, atomic.LoadInt64(&c.budgetWindowStart))
+	if time.Since(start) >= p.byteBudgetWindow() {
+		return int64(p.ByteBudgetPerWindow)
+	}
+	remaining := int64(p.ByteBudgetPerWindow) - atomic.LoadInt64(&c.budgetUsed)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
@@ -0,0 +1,45 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// batchChecksum returns the hex encoded SHA-256 over each event of the batch in order, as its timestamp
+// in milliseconds in decimal, a space, its message and a newline.
+func batchChecksum(events []*cloudwatchlogs.InputLogEvent) string {
+	h := sha256.New()
+	var buf []byte
+	for _, e := range events {
+		buf = strconv.AppendInt(buf[:0], *e.Timestamp, 10)
+		buf = append(buf, ' ')
+		buf = append(buf, *e.Message...)
+		buf = append(buf, '\n')
+		h.Write(buf)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// auditBatch logs and reports the checksum of a delivered batch with the sequence token returned for it.
+func (p *pusher) auditBatch(events []*cloudwatchlogs.InputLogEvent, token *string) {
+	if !p.BatchChecksum && p.OnBatchChecksum == nil {
+		return
+	}
+	sum := batchChecksum(events)
+	if p.BatchChecksum {
+		t := "<nil>"
+		if token != nil {
+			t = *token
+		}
+		p.Log.Infof("Delivered %v log events to %v/%v with checksum sha256:%v, next sequence token %v", len(events), p.Group, p.Stream, sum, t)
+	}
+	if p.OnBatchChecksum != nil {
+		p.OnBatchChecksum(p.Group, p.Stream, sum, token)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// clockSkewThreshold is the median offset of the events ahead of now above which a batch is skewed.
+	clockSkewThreshold = time.Minute
+	// clockSkewBatches is the number of consecutive skewed batches for the skew to be considered sustained.
+	clockSkewBatches = 3
+)
+
+// observeSkew records the offset of an event time from now for the skew estimation of the current
+// batch, and returns the timestamp in milliseconds to send, shifted back when the skew is clamped.
+func (p *pusher) observeSkew(et time.Time, t int64) int64 {
+	p.skewSamples = append(p.skewSamples, et.Sub(time.Now()))
+	if p.ClampClockSkew && p.clockSkew > 0 {
+		t -= int64(p.clockSkew / time.Millisecond)
+	}
+	return t
+}
+
+// estimateSkew updates the clock skew from the median offset of the events of the batch about to be sent.
+// The skew is only reported once the median is ahead of now by clockSkewThreshold for clockSkewBatches
+// batches in a row, so a few events with future timestamps are not mistaken for a bad clock.
+func (p *pusher) estimateSkew() {
+	if len(p.skewSamples) == 0 {
+		return
+	}
+	sort.Slice(p.skewSamples, func(i, j int) bool { return p.skewSamples[i] < p.skewSamples[j] })
+	median := p.skewSamples[len(p.skewSamples)/2]
+	p.skewSamples = p.skewSamples[:0]
+
+	if median < clockSkewThreshold {
+		if p.clockSkew > 0 {
+			p.Log.Infof("Log events for %v/%v are no longer ahead of the current time", p.Group, p.Stream)
+		}
+		p.skewedBatches = 0
+		p.setClockSkew(0)
+		return
+	}
+
+	p.skewedBatches++
+	if p.skewedBatches < clockSkewBatches {
+		return
+	}
+	if p.clockSkew == 0 {
+		if p.ClampClockSkew {
+			p.Log.Warnf("Log events for %v/%v are ahead of the current time by about %v, the host clock is likely skewed, clamping the timestamps", p.Group, p.Stream, median)
+		} else {
+			p.Log.Warnf("Log events for %v/%v are ahead of the current time by about %v, the host clock is likely skewed", p.Group, p.Stream, median)
+		}
+	}
+	// The clamped events still record their original offset, so the estimate does not drift
+	p.setClockSkew(median)
+}
+
+func (p *pusher) setClockSkew(d time.Duration) {
+	p.clockSkew = d
+	atomic.StoreInt64(&p.counters.clockSkew, int64(d))
+}
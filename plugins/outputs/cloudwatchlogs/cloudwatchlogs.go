@@ -6,6 +6,7 @@ package cloudwatchlogs
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"strings"
 	"sync"
 	"time"
@@ -16,7 +17,11 @@ import (
 	"github.com/aws/amazon-cloudwatch-agent/internal"
 	"github.com/aws/amazon-cloudwatch-agent/logs"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/plugins/outputs"
@@ -37,6 +42,8 @@ const (
 	metricRetryTimeout = 2 * time.Minute
 
 	attributesInFields = "attributesInFields"
+
+	errCodeAssumeRoleFailed = "AssumeRoleFailed"
 )
 
 type CloudWatchLogs struct {
@@ -55,9 +62,28 @@ type CloudWatchLogs struct {
 
 	ForceFlushInterval internal.Duration `toml:"force_flush_interval"` // unit is second
 
+	// HTTPClient is the HTTP client of the CloudWatch Logs clients, such as one from NewHTTPClient to tune
+	// the connection reuse. The default client of the SDK is used when nil.
+	HTTPClient *http.Client `toml:"-"`
+
+	// CreateCallsPerMinute limits the log group and stream creations of the destinations of each account and
+	// region, 0 for no limit
+	CreateCallsPerMinute int `toml:"create_calls_per_minute"`
+	// MaxConcurrentCreates limits the log group and stream creations of the destinations of each account and
+	// region in progress at once, 0 for no limit
+	MaxConcurrentCreates int `toml:"max_concurrent_creates"`
+
+	// PublishDroppedMetric publishes the log events dropped by each destination as the DroppedLogEvents
+	// CloudWatch metric, every DroppedMetricInterval, a minute when 0
+	PublishDroppedMetric  bool              `toml:"publish_dropped_metric"`
+	DroppedMetricInterval internal.Duration `toml:"dropped_metric_interval"`
+
 	Log telegraf.Logger `toml:"-"`
 
-	cwDests map[Target]*cwDest
+	cwDestsMu        sync.Mutex
+	cwDests          map[Target]*cwDest
+	credentials      map[string]*roleCredentials
+	logGroupManagers map[string]*LogGroupManager // by role and region
 }
 
 func (c *CloudWatchLogs) Connect() error {
@@ -65,6 +91,8 @@ func (c *CloudWatchLogs) Connect() error {
 }
 
 func (c *CloudWatchLogs) Close() error {
+	c.cwDestsMu.Lock()
+	defer c.cwDestsMu.Unlock()
 	for _, d := range c.cwDests {
 		d.Stop()
 	}
@@ -94,33 +122,136 @@ func (c *CloudWatchLogs) CreateDest(group, stream string) logs.LogDest {
 }
 
 func (c *CloudWatchLogs) getDest(t Target) *cwDest {
+	c.cwDestsMu.Lock()
+	defer c.cwDestsMu.Unlock()
 	if cwd, ok := c.cwDests[t]; ok {
 		return cwd
 	}
 
-	credentialConfig := &configaws.CredentialConfig{
-		Region:    c.Region,
-		AccessKey: c.AccessKey,
-		SecretKey: c.SecretKey,
-		RoleARN:   c.RoleARN,
-		Profile:   c.Profile,
-		Filename:  c.Filename,
-		Token:     c.Token,
+	client := c.newService(t.RoleARN)
+
+	pusher := NewPusher(t, client, c.ForceFlushInterval.Duration, maxRetryTimeout, c.Log)
+	pusher.LogGroupManager = c.logGroupManager(t.RoleARN)
+	if c.PublishDroppedMetric {
+		pusher.DroppedMetricService = c.newMetricsService(t.RoleARN)
+		pusher.DroppedMetricInterval = c.DroppedMetricInterval.Duration
+	}
+	cwd := &cwDest{pusher: pusher, owner: c}
+	c.cwDests[t] = cwd
+	return cwd
+}
+
+// logGroupManager returns the LogGroupManager of the destinations using the given role, or the role of
+// the plugin when empty. The create limits are per account and region like the API limits, so the creations
+// of a destination never wait for the ones of another account.
+func (c *CloudWatchLogs) logGroupManager(roleARN string) *LogGroupManager {
+	if roleARN == "" {
+		roleARN = c.RoleARN
+	}
+	key := roleARN + "@" + c.Region
+	if c.logGroupManagers == nil {
+		c.logGroupManagers = make(map[string]*LogGroupManager)
 	}
+	m, ok := c.logGroupManagers[key]
+	if !ok {
+		m = NewLogGroupManager(c.CreateCallsPerMinute)
+		m.MaxConcurrentCreates = c.MaxConcurrentCreates
+		c.logGroupManagers[key] = m
+	}
+	return m
+}
 
+// newService builds a CloudWatch Logs client using the given role, or the role of the plugin when empty.
+// The credentials are cached per role and refreshed automatically, so the clients of a role share them.
+// Nothing else is shared but the create limits of the account: each target gets its own client and retryer,
+// and each pusher backs off on its own, so the throttling of an account or region never delays the delivery
+// to the others.
+func (c *CloudWatchLogs) newService(roleARN string) *logsClient {
+	rc := c.roleCredentials(roleARN)
 	client := cloudwatchlogs.New(
-		credentialConfig.Credentials(),
+		rc.provider,
 		&aws.Config{
-			Endpoint: aws.String(c.EndpointOverride),
+			Endpoint:    aws.String(c.EndpointOverride),
+			Credentials: rc.credentials,
+			HTTPClient:  c.HTTPClient,
 		},
 	)
 	client.Handlers.Build.PushBackNamed(handlers.NewRequestCompressionHandler([]string{"PutLogEvents"}))
 	client.Handlers.Build.PushBackNamed(handlers.NewCustomHeaderHandler("User-Agent", agentinfo.UserAgent()))
+	return &logsClient{client}
+}
 
-	pusher := NewPusher(t, client, c.ForceFlushInterval.Duration, maxRetryTimeout, c.Log)
-	cwd := &cwDest{pusher: pusher}
-	c.cwDests[t] = cwd
-	return cwd
+// newMetricsService builds a CloudWatch client using the given role, or the role of the plugin when empty,
+// to publish the metrics of the pushers. The endpoint override of CloudWatch Logs does not apply to it.
+func (c *CloudWatchLogs) newMetricsService(roleARN string) *cloudwatch.CloudWatch {
+	rc := c.roleCredentials(roleARN)
+	client := cloudwatch.New(
+		rc.provider,
+		&aws.Config{
+			Credentials: rc.credentials,
+			HTTPClient:  c.HTTPClient,
+		},
+	)
+	client.Handlers.Build.PushBackNamed(handlers.NewCustomHeaderHandler("User-Agent", agentinfo.UserAgent()))
+	return client
+}
+
+// roleCredentials returns the cached credentials of the given role, or the role of the plugin when empty.
+func (c *CloudWatchLogs) roleCredentials(roleARN string) *roleCredentials {
+	if roleARN == "" {
+		roleARN = c.RoleARN
+	}
+
+	if c.credentials == nil {
+		c.credentials = make(map[string]*roleCredentials)
+	}
+	rc, ok := c.credentials[roleARN]
+	if !ok {
+		credentialConfig := &configaws.CredentialConfig{
+			Region:    c.Region,
+			AccessKey: c.AccessKey,
+			SecretKey: c.SecretKey,
+			RoleARN:   roleARN,
+			Profile:   c.Profile,
+			Filename:  c.Filename,
+			Token:     c.Token,
+		}
+		rc = &roleCredentials{provider: credentialConfig.Credentials()}
+		if roleARN != "" {
+			rc.credentials = credentials.NewCredentials(&assumeRoleProvider{
+				roleARN:     roleARN,
+				credentials: rc.provider.ClientConfig(cloudwatchlogs.ServiceName).Config.Credentials,
+			})
+		}
+		c.credentials[roleARN] = rc
+	}
+	return rc
+}
+
+// roleCredentials holds the cached credentials of a role. credentials is nil when no role is assumed,
+// the credentials of the provider are used as is.
+type roleCredentials struct {
+	provider    client.ConfigProvider
+	credentials *credentials.Credentials
+}
+
+// assumeRoleProvider reports the failures to get the credentials of an assumed role with
+// errCodeAssumeRoleFailed, so they are not mistaken for a PutLogEvents failure.
+type assumeRoleProvider struct {
+	roleARN     string
+	credentials *credentials.Credentials
+}
+
+func (a *assumeRoleProvider) Retrieve() (credentials.Value, error) {
+	v, err := a.credentials.Get()
+	if err != nil {
+		return v, awserr.New(errCodeAssumeRoleFailed, fmt.Sprintf("unable to assume role %v", a.roleARN), err)
+	}
+	return v, nil
+}
+
+func (a *assumeRoleProvider) IsExpired() bool {
+	return a.credentials.IsExpired()
 }
 
 func (c *CloudWatchLogs) writeMetricAsStructuredLog(m telegraf.Metric) {
@@ -160,7 +291,7 @@ func (c *CloudWatchLogs) getTargetFromMetric(m telegraf.Metric) (Target, error)
 		logStream = c.LogStreamName
 	}
 
-	return Target{logGroup, logStream}, nil
+	return Target{Group: logGroup, Stream: logStream}, nil
 }
 
 func (c *CloudWatchLogs) getLogEventFromMetric(metric telegraf.Metric) *structuredLogEvent {
@@ -258,6 +389,36 @@ type cwDest struct {
 	sync.Mutex
 	isEMF   bool
 	stopped bool
+	owner   *CloudWatchLogs // the plugin the destination is filed in, by target
+}
+
+// SetTarget switches the destination to a new log group and stream like the SetTarget of its pusher, and
+// files it under the new target, so CreateDest returns it for the new target and creates a new destination
+// for the previous one. It fails when the new target already has a destination.
+func (cd *cwDest) SetTarget(t Target) error {
+	c := cd.owner
+	c.cwDestsMu.Lock()
+	if d, ok := c.cwDests[t]; ok && d != cd {
+		c.cwDestsMu.Unlock()
+		return fmt.Errorf("log group and stream %v/%v already have a destination", t.Group, t.Stream)
+	}
+	// The new target is reserved while the pusher switches, which waits for its send loop, so the other
+	// destinations are not blocked meanwhile
+	previous := cd.target()
+	c.cwDests[t] = cd
+	c.cwDestsMu.Unlock()
+
+	err := cd.pusher.SetTarget(t)
+
+	c.cwDestsMu.Lock()
+	defer c.cwDestsMu.Unlock()
+	current := cd.target()
+	for _, k := range []Target{previous, t} {
+		if k != current && c.cwDests[k] == cd {
+			delete(c.cwDests, k)
+		}
+	}
+	return err
 }
 
 func (cd *cwDest) Publish(events []logs.LogEvent) error {
@@ -295,7 +456,7 @@ func (cd *cwDest) switchToEMF() {
 	defer cd.Unlock()
 	if !cd.isEMF {
 		cd.isEMF = true
-		cwl, ok := cd.Service.(*cloudwatchlogs.CloudWatchLogs)
+		cwl, ok := cd.Service.(*logsClient)
 		if ok {
 			cwl.Handlers.Build.PushBackNamed(handlers.NewCustomHeaderHandler("x-amzn-logs-format", "json/emf"))
 		}
@@ -303,7 +464,7 @@ func (cd *cwDest) switchToEMF() {
 }
 
 func (cd *cwDest) setRetryer(r request.Retryer) {
-	cwl, ok := cd.Service.(*cloudwatchlogs.CloudWatchLogs)
+	cwl, ok := cd.Service.(*logsClient)
 	if ok {
 		cwl.Retryer = r
 	}
@@ -311,6 +472,8 @@ func (cd *cwDest) setRetryer(r request.Retryer) {
 
 type Target struct {
 	Group, Stream string
+	// RoleARN is the role assumed to send to the target, the role of the plugin is used when empty.
+	RoleARN string
 }
 
 // Description returns a one-sentence description on the Output
@@ -4,8 +4,20 @@
 package cloudwatchlogs
 
 import (
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/aws/amazon-cloudwatch-agent/logs"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+
+	"github.com/influxdata/telegraf/models"
 	"github.com/influxdata/telegraf/plugins/outputs"
 )
 
@@ -44,3 +56,303 @@ func TestCreateDest(t *testing.T) {
 		t.Errorf("Empty create dest should return dest to default group and stream, %v/%v found", d.pusher.Group, d.pusher.Stream)
 	}
 }
+
+func TestNewServicePerRole(t *testing.T) {
+	c := outputs.Outputs["cloudwatchlogs"]().(*CloudWatchLogs)
+	c.Region = "us-east-1"
+
+	a1 := c.newService("arn:aws:iam::111111111111:role/A")
+	a2 := c.newService("arn:aws:iam::111111111111:role/A")
+	b := c.newService("arn:aws:iam::222222222222:role/B")
+
+	if a1 == a2 {
+		t.Errorf("Each call should build its own client")
+	}
+	if a1.Config.Credentials != a2.Config.Credentials {
+		t.Errorf("Clients of the same role should share the cached credentials")
+	}
+	if a1.Config.Credentials == b.Config.Credentials {
+		t.Errorf("Clients of different roles should not share credentials")
+	}
+	if len(c.credentials) != 2 {
+		t.Errorf("Expecting credentials cached for 2 roles, %d found", len(c.credentials))
+	}
+}
+
+func TestCreateDestDroppedMetric(t *testing.T) {
+	c := outputs.Outputs["cloudwatchlogs"]().(*CloudWatchLogs)
+	c.Region = "us-east-1"
+	c.Log = models.NewLogger("cloudwatchlogs", "test", "")
+	defer c.Close()
+
+	if d := c.CreateDest("G1", "S").(*cwDest); d.pusher.DroppedMetricService != nil {
+		t.Errorf("Expecting no dropped metric unless configured")
+	}
+	c.PublishDroppedMetric = true
+	c.DroppedMetricInterval.Duration = 30 * time.Second
+	d := c.CreateDest("G2", "S").(*cwDest)
+	if d.pusher.DroppedMetricService == nil || d.pusher.DroppedMetricInterval != 30*time.Second {
+		t.Errorf("Expecting the dropped metric published every 30s, got %v every %v", d.pusher.DroppedMetricService, d.pusher.DroppedMetricInterval)
+	}
+}
+
+func TestKnownStreamsPerEndpoint(t *testing.T) {
+	target := Target{Group: "G", Stream: "S"}
+	var keys []streamKey
+	for _, region := range []string{"us-east-1", "eu-west-1"} {
+		c := outputs.Outputs["cloudwatchlogs"]().(*CloudWatchLogs)
+		c.Region = region
+		p := NewPusher(target, c.newService(""), time.Hour, maxRetryTimeout, models.NewLogger("cloudwatchlogs", "test", ""))
+		p.Stop()
+		keys = append(keys, p.streamKey())
+	}
+	knownStreams.add(keys[0])
+	defer knownStreams.remove(keys[0])
+	if keys[0] == keys[1] || knownStreams.has(keys[1]) {
+		t.Errorf("Expecting the same log stream in another region not known to exist, got keys %v", keys)
+	}
+}
+
+func TestCreateLimitsIsolatedPerAccount(t *testing.T) {
+	c := outputs.Outputs["cloudwatchlogs"]().(*CloudWatchLogs)
+	c.Region = "us-east-1"
+	c.CreateCallsPerMinute = 1
+	roleA, roleB := "arn:aws:iam::111111111111:role/A", "arn:aws:iam::222222222222:role/B"
+	if c.logGroupManager(roleA) != c.logGroupManager(roleA) || c.logGroupManager(roleA) == c.logGroupManager(roleB) {
+		t.Fatalf("Expecting one LogGroupManager per account")
+	}
+
+	var s svcMock
+	s.dls = func(*cloudwatchlogs.DescribeLogStreamsInput) (*cloudwatchlogs.DescribeLogStreamsOutput, error) {
+		return &cloudwatchlogs.DescribeLogStreamsOutput{}, nil
+	}
+	s.cls = func(*cloudwatchlogs.CreateLogStreamInput) (*cloudwatchlogs.CreateLogStreamOutput, error) {
+		return &cloudwatchlogs.CreateLogStreamOutput{}, nil
+	}
+	delivered := make(chan string, 1)
+	s.ple = func(in *cloudwatchlogs.PutLogEventsInput) (*cloudwatchlogs.PutLogEventsOutput, error) {
+		delivered <- *in.LogStreamName
+		return &cloudwatchlogs.PutLogEventsOutput{}, nil
+	}
+	newPusher := func(stream, role string) *pusher {
+		p := NewPusher(Target{Group: "G", Stream: stream, RoleARN: role}, &s, 10*time.Millisecond, maxRetryTimeout, models.NewLogger("cloudwatchlogs", "test", ""))
+		p.LogGroupManager = c.logGroupManager(role)
+		return p
+	}
+
+	// The creations of account A use up its limit
+	a1, a2 := newPusher("isolated-a1", roleA), newPusher("isolated-a2", roleA)
+	defer a1.Stop()
+	defer a2.Stop()
+	if err := a1.createLogGroupAndStream(); err != nil {
+		t.Fatal(err)
+	}
+	if err := a2.createLogGroupAndStream(); err != errCreateThrottled {
+		t.Errorf("Expecting the next creation of account A throttled, got %v", err)
+	}
+
+	// Meanwhile account B still creates its stream and delivers
+	b := newPusher("isolated-b", roleB)
+	defer b.Stop()
+	if err := b.createLogGroupAndStream(); err != nil {
+		t.Errorf("Expecting the creation of account B not throttled by account A, got %v", err)
+	}
+	b.AddEvent(evtMock{"msg", time.Now(), nil})
+	select {
+	case stream := <-delivered:
+		if stream != "isolated-b" {
+			t.Errorf("Expecting the event delivered to account B, got %v", stream)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("The delivery to account B should not wait for account A")
+	}
+}
+
+func TestPutIndexPolicyRequest(t *testing.T) {
+	var target, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target = r.Header.Get("X-Amz-Target")
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	c := outputs.Outputs["cloudwatchlogs"]().(*CloudWatchLogs)
+	c.Region = "us-east-1"
+	c.AccessKey = "AKID"
+	c.SecretKey = "SECRET"
+	c.EndpointOverride = server.URL
+
+	_, err := c.newService("").PutIndexPolicy(&PutIndexPolicyInput{
+		LogGroupIdentifier: aws.String("G"),
+		PolicyDocument:     aws.String(`{"Fields":["status"]}`),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error putting the index policy: %v", err)
+	}
+	if target != "Logs_20140328.PutIndexPolicy" {
+		t.Errorf("Unexpected target %q", target)
+	}
+	if body != `{"logGroupIdentifier":"G","policyDocument":"{\"Fields\":[\"status\"]}"}` {
+		t.Errorf("Unexpected request body %s", body)
+	}
+}
+
+func TestPutLogEventsWithEntityRequest(t *testing.T) {
+	var target, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target = r.Header.Get("X-Amz-Target")
+		var reader io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			reader, _ = gzip.NewReader(r.Body)
+		}
+		b, _ := ioutil.ReadAll(reader)
+		body = string(b)
+		w.Write([]byte(`{"nextSequenceToken":"T"}`))
+	}))
+	defer server.Close()
+
+	c := outputs.Outputs["cloudwatchlogs"]().(*CloudWatchLogs)
+	c.Region = "us-east-1"
+	c.AccessKey = "AKID"
+	c.SecretKey = "SECRET"
+	c.EndpointOverride = server.URL
+
+	entity := &Entity{KeyAttributes: map[string]*string{"Type": aws.String("Service"), "Name": aws.String("checkout")}}
+	output, err := c.newService("").PutLogEventsWithEntity(context.Background(), &cloudwatchlogs.PutLogEventsInput{
+		LogEvents:     []*cloudwatchlogs.InputLogEvent{{Message: aws.String("msg"), Timestamp: aws.Int64(1)}},
+		LogGroupName:  aws.String("G"),
+		LogStreamName: aws.String("S"),
+	}, entity)
+	if err != nil {
+		t.Fatalf("Unexpected error putting the log events: %v", err)
+	}
+	if target != "Logs_20140328.PutLogEvents" || aws.StringValue(output.NextSequenceToken) != "T" {
+		t.Errorf("Unexpected target %q or output %v", target, output)
+	}
+	if body != `{"entity":{"keyAttributes":{"Name":"checkout","Type":"Service"}},"logEvents":[{"message":"msg","timestamp":1}],"logGroupName":"G","logStreamName":"S"}` {
+		t.Errorf("Unexpected request body %s", body)
+	}
+
+	if err := (&Entity{KeyAttributes: map[string]*string{"Type": aws.String("Service")}}).Validate(); err == nil {
+		t.Errorf("Expecting an entity with a single key attribute to be invalid")
+	}
+	if err := entity.Validate(); err != nil {
+		t.Errorf("Unexpected error validating the entity: %v", err)
+	}
+}
+
+func TestPusherEndpoint(t *testing.T) {
+	c := outputs.Outputs["cloudwatchlogs"]().(*CloudWatchLogs)
+	c.Region = "us-west-2"
+	c.EndpointOverride = "https://logs.example.com"
+
+	p := NewPusher(Target{Group: "G", Stream: "S"}, c.newService(""), time.Hour, maxRetryTimeout, models.NewLogger("cloudwatchlogs", "test", ""))
+	defer p.Stop()
+	if region, url := p.Endpoint(); region != "us-west-2" || url != "https://logs.example.com" {
+		t.Errorf("Expecting the endpoint of the SDK client, got %q %q", region, url)
+	}
+	if stats := p.Stats(); stats.Region != "us-west-2" || stats.Endpoint != "https://logs.example.com" {
+		t.Errorf("Expecting the endpoint in the stats, got %q %q", stats.Region, stats.Endpoint)
+	}
+
+	var s svcMock
+	m := NewPusher(Target{Group: "G", Stream: "S"}, &s, time.Hour, maxRetryTimeout, models.NewLogger("cloudwatchlogs", "test", ""))
+	defer m.Stop()
+	if region, url := m.Endpoint(); region != "" || url != "" {
+		t.Errorf("Expecting no endpoint without an SDK client, got %q %q", region, url)
+	}
+}
+
+func TestNewServiceHTTPClient(t *testing.T) {
+	c := outputs.Outputs["cloudwatchlogs"]().(*CloudWatchLogs)
+	c.Region = "us-east-1"
+
+	if client := c.newService("").Config.HTTPClient; client != http.DefaultClient {
+		t.Errorf("Expecting the default client of the SDK, got %v", client)
+	}
+
+	c.HTTPClient = NewHTTPClient(100, 30*time.Second)
+	client := c.newService("").Config.HTTPClient
+	if client != c.HTTPClient {
+		t.Errorf("Expecting the HTTP client of the plugin, got %v", client)
+	}
+	if transport := client.Transport.(*http.Transport); transport.MaxIdleConnsPerHost != 100 || client.Timeout != 30*time.Second {
+		t.Errorf("Expecting 100 idle connections per host and a 30s timeout, got %v and %v", transport.MaxIdleConnsPerHost, client.Timeout)
+	}
+}
+
+func TestCwDestSetTargetRekeys(t *testing.T) {
+	var s svcMock
+	c := &CloudWatchLogs{cwDests: make(map[Target]*cwDest)}
+	old, next := Target{Group: "G", Stream: "S"}, Target{Group: "G", Stream: "rekeyed"}
+	p := NewPusher(old, &s, 1*time.Hour, maxRetryTimeout, models.NewLogger("cloudwatchlogs", "test", ""))
+	defer p.Stop()
+	cd := &cwDest{pusher: p, owner: c}
+	c.cwDests[old] = cd
+	other := &cwDest{pusher: p, owner: c}
+	c.cwDests[Target{Group: "G", Stream: "taken"}] = other
+
+	if err := cd.SetTarget(Target{Group: "G", Stream: "taken"}); err == nil {
+		t.Errorf("Expecting SetTarget to fail on the target of another destination")
+	}
+	if err := cd.SetTarget(next); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.cwDests[old]; ok || c.cwDests[next] != cd {
+		t.Errorf("Expecting the destination filed under its new target only, got %v", c.cwDests)
+	}
+}
+
+func TestCwDestSetTargetDoesNotBlockOthers(t *testing.T) {
+	var s svcMock
+	sending := make(chan struct{})
+	release := make(chan struct{})
+	s.ple = func(in *cloudwatchlogs.PutLogEventsInput) (*cloudwatchlogs.PutLogEventsOutput, error) {
+		close(sending)
+		<-release
+		return &cloudwatchlogs.PutLogEventsOutput{}, nil
+	}
+	c := outputs.Outputs["cloudwatchlogs"]().(*CloudWatchLogs)
+	c.Region = "us-east-1"
+	c.Log = models.NewLogger("cloudwatchlogs", "test", "")
+	old, next := Target{Group: "G", Stream: "S"}, Target{Group: "G", Stream: "next"}
+	p := NewPusher(old, &s, 1*time.Hour, maxRetryTimeout, models.NewLogger("cloudwatchlogs", "test", ""))
+	cd := &cwDest{pusher: p, owner: c}
+	c.cwDests[old] = cd
+
+	// The send loop is busy sending while the destination switches
+	p.AddEvent(evtMock{"msg", time.Now(), nil})
+	p.control(p.send)
+	<-sending
+	switched := make(chan error)
+	go func() { switched <- cd.SetTarget(next) }()
+	for reserved := false; !reserved; time.Sleep(time.Millisecond) {
+		c.cwDestsMu.Lock()
+		reserved = c.cwDests[next] == cd
+		c.cwDestsMu.Unlock()
+	}
+
+	created := make(chan logs.LogDest)
+	go func() { created <- c.CreateDest("G", "other") }()
+	select {
+	case <-created:
+	case <-time.After(time.Second):
+		t.Errorf("Expecting the destinations created while another one switches target")
+	}
+	if d := c.CreateDest("G", "next"); d != cd {
+		t.Errorf("Expecting the new target reserved for the destination switching to it")
+	}
+
+	close(release)
+	if err := <-switched; err != nil {
+		t.Fatal(err)
+	}
+	c.cwDestsMu.Lock()
+	if _, ok := c.cwDests[old]; ok || c.cwDests[next] != cd {
+		t.Errorf("Expecting the destination filed under its new target only, got %v", c.cwDests)
+	}
+	c.cwDestsMu.Unlock()
+	c.Close()
+}
@@ -0,0 +1,318 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"regexp"
+	"sort"
+	"time"
+)
+
+// maskedValue replaces the values of the sensitive keys in a PusherConfigSnapshot.
+const maskedValue = "****"
+
+// sensitiveKeyRegex matches the keys of the fields and attributes whose values are masked in a PusherConfigSnapshot.
+var sensitiveKeyRegex = regexp.MustCompile(`(?i)password|passwd|secret|token|api[_-]?key|credential|private[_-]?key`)
+
+// PusherConfigSnapshot is the effective configuration of a pusher, with the defaults applied to the options
+// left unset, for support bundles and debugging. The durations are in the format of time.Duration.String.
+// The functions, writers and sinks are only listed by option name in Hooks when set, and the values of the
+// envelope fields and entity attributes with a sensitive key, such as a token or password, are masked.
+type PusherConfigSnapshot struct {
+	Group   string `json:"group"`
+	Stream  string `json:"stream"`
+	RoleARN string `json:"role_arn,omitempty"`
+
+	FlushTimeout    string  `json:"flush_timeout"`
+	AdaptiveFlush   bool    `json:"adaptive_flush"`
+	MinFlushTimeout string  `json:"min_flush_timeout,omitempty"`
+	MaxFlushTimeout string  `json:"max_flush_timeout,omitempty"`
+	FlushJitter     float64 `json:"flush_jitter,omitempty"`
+	NoBatch         bool    `json:"no_batch"`
+	MaxBatchEvents  int     `json:"max_batch_events"`
+	MinBatchEvents  int     `json:"min_batch_events,omitempty"`
+	MaxBatchAge     string  `json:"max_batch_age,omitempty"`
+	MaxBatchWait    string  `json:"max_batch_wait,omitempty"`
+	EventHeaderSize int     `json:"event_header_size"`
+	MsgSizeLimit    int     `json:"msg_size_limit"`
+	SplitOversized  bool    `json:"split_oversized"`
+	SortEvents      bool    `json:"sort_events"`
+
+	RetryDuration           string            `json:"retry_duration"`
+	RetryQueueSize          int               `json:"retry_queue_size"`
+	DropAfterRetryDuration  bool              `json:"drop_after_retry_duration"`
+	MaxDeliveryLatency      string            `json:"max_delivery_latency,omitempty"`
+	MaxSequenceTokenRetries int               `json:"max_sequence_token_retries"`
+	ErrorPolicies           map[string]string `json:"error_policies"`
+
+	ByteBudgetPerWindow int    `json:"byte_budget_per_window,omitempty"`
+	ByteBudgetWindow    string `json:"byte_budget_window,omitempty"`
+	DropOverBudget      bool   `json:"drop_over_budget,omitempty"`
+	PauseBufferSize     int    `json:"pause_buffer_size"`
+
+	SamplingUnderPressure bool   `json:"sampling_under_pressure"`
+	SamplingHighWater     int    `json:"sampling_high_water,omitempty"`
+	SamplingAfter         string `json:"sampling_after,omitempty"`
+	SamplingRate          int    `json:"sampling_rate,omitempty"`
+
+	DedupTTL       string `json:"dedup_ttl,omitempty"`
+	DedupCacheSize int    `json:"dedup_cache_size,omitempty"`
+
+	RetentionBound           bool     `json:"retention_bound"`
+	RetentionRefreshInterval string   `json:"retention_refresh_interval,omitempty"`
+	DroppedMetric            bool     `json:"dropped_metric"`
+	DroppedMetricInterval    string   `json:"dropped_metric_interval,omitempty"`
+	FieldIndexes             []string `json:"field_indexes,omitempty"`
+	MetricFilters            []string `json:"metric_filters,omitempty"`
+	ValidateExistingStream   bool     `json:"validate_existing_stream"`
+	StaleStreamAge           string   `json:"stale_stream_age,omitempty"`
+
+	MaxStreams        int    `json:"max_streams"`
+	StreamIdleTimeout string `json:"stream_idle_timeout"`
+	ShardCount        int    `json:"shard_count,omitempty"`
+
+	TimestampSource        string          `json:"timestamp_source"`
+	EmbedOtherTime         bool            `json:"embed_other_time,omitempty"`
+	TimestampRounding      string          `json:"timestamp_rounding,omitempty"`
+	DetectClockSkew        bool            `json:"detect_clock_skew"`
+	ClampClockSkew         bool            `json:"clamp_clock_skew"`
+	DetectTimestampUnits   bool            `json:"detect_timestamp_units"`
+	CorrectTimestampUnits  bool            `json:"correct_timestamp_units"`
+	SanitizeControlChars   bool            `json:"sanitize_control_chars"`
+	RedactionRules         int             `json:"redaction_rules"`
+	CollapseRepeatedFrames bool            `json:"collapse_repeated_frames"`
+	ProjectFields          []string        `json:"project_fields,omitempty"`
+	EnrichFields           []string        `json:"enrich_fields,omitempty"`
+	EnvelopeFormat         *EnvelopeFormat `json:"envelope_format,omitempty"`
+	InjectSequenceNumber   bool            `json:"inject_sequence_number"`
+	DropFlushMarkers       bool            `json:"drop_flush_markers"`
+	StartupSyncEvents      int             `json:"startup_sync_events,omitempty"`
+	HeartbeatInterval      string          `json:"heartbeat_interval,omitempty"`
+	HeartbeatMessage       string          `json:"heartbeat_message,omitempty"`
+	BatchChecksum          bool            `json:"batch_checksum"`
+
+	SinkStrategy           string `json:"sink_strategy"`
+	TeeDurability          string `json:"tee_durability,omitempty"`
+	DroppedEventDonePolicy string `json:"dropped_event_done_policy"`
+	DoneCallbackDelay      string `json:"done_callback_delay,omitempty"`
+	SuppressDoneOnShutdown bool   `json:"suppress_done_on_shutdown"`
+	WAL                    bool   `json:"wal"`
+	LogGroupManager        bool   `json:"log_group_manager"`
+
+	Entity map[string]map[string]string `json:"entity,omitempty"`
+	Hooks  []string                     `json:"hooks,omitempty"`
+}
+
+// Config returns the effective configuration of the pusher. Like the options, it must not be called
+// concurrently with changes to them.
+func (p *pusher) Config() PusherConfigSnapshot {
+	t := p.target()
+	c := PusherConfigSnapshot{
+		Group:   t.Group,
+		Stream:  t.Stream,
+		RoleARN: t.RoleARN,
+
+		FlushTimeout:    p.FlushTimeout.String(),
+		AdaptiveFlush:   p.AdaptiveFlush,
+		FlushJitter:     p.FlushJitter,
+		NoBatch:         p.NoBatch,
+		MaxBatchEvents:  p.maxBatchEvents(),
+		MinBatchEvents:  p.MinBatchEvents,
+		MaxBatchAge:     optionalDuration(p.MaxBatchAge),
+		MaxBatchWait:    optionalDuration(p.MaxBatchWait),
+		EventHeaderSize: p.eventHeaderSize(),
+		MsgSizeLimit:    p.msgSizeLimit(),
+		SplitOversized:  p.SplitOversized,
+		SortEvents:      p.SortEvents,
+
+		RetryDuration:           p.RetryDuration.String(),
+		RetryQueueSize:          p.RetryQueueSize,
+		DropAfterRetryDuration:  p.DropAfterRetryDuration,
+		MaxDeliveryLatency:      optionalDuration(p.MaxDeliveryLatency),
+		MaxSequenceTokenRetries: p.maxSequenceTokenRetries(),
+		ErrorPolicies:           make(map[string]string),
+
+		PauseBufferSize: p.PauseBufferSize,
+
+		SamplingUnderPressure: p.SamplingUnderPressure,
+
+		RetentionBound:         p.RetentionBound,
+		DroppedMetric:          p.DroppedMetricService != nil,
+		FieldIndexes:           p.FieldIndexes,
+		ValidateExistingStream: p.ValidateExistingStream,
+
+		MaxStreams:        p.MaxStreams,
+		StreamIdleTimeout: p.StreamIdleTimeout.String(),
+
+		TimestampSource:        p.TimestampSource.String(),
+		EmbedOtherTime:         p.EmbedOtherTime,
+		TimestampRounding:      optionalDuration(p.TimestampRounding),
+		DetectClockSkew:        p.DetectClockSkew,
+		ClampClockSkew:         p.ClampClockSkew,
+		DetectTimestampUnits:   p.DetectTimestampUnits,
+		CorrectTimestampUnits:  p.CorrectTimestampUnits,
+		SanitizeControlChars:   p.SanitizeControlChars,
+		RedactionRules:         len(p.RedactionRules),
+		CollapseRepeatedFrames: p.CollapseRepeatedFrames,
+		ProjectFields:          p.ProjectFields,
+		EnrichFields:           p.EnrichFields,
+		InjectSequenceNumber:   p.InjectSequenceNumber,
+		DropFlushMarkers:       p.DropFlushMarkers,
+		StartupSyncEvents:      p.StartupSyncEvents,
+		BatchChecksum:          p.BatchChecksum,
+
+		SinkStrategy:           p.SinkStrategy.String(),
+		DroppedEventDonePolicy: p.DroppedEventDonePolicy.String(),
+		DoneCallbackDelay:      optionalDuration(p.DoneCallbackDelay),
+		SuppressDoneOnShutdown: p.SuppressDoneOnShutdown,
+		WAL:                    p.WAL != nil,
+		LogGroupManager:        p.LogGroupManager != nil,
+	}
+	if p.AdaptiveFlush {
+		c.MinFlushTimeout = p.MinFlushTimeout.String()
+		c.MaxFlushTimeout = p.MaxFlushTimeout.String()
+	}
+	for category, policy := range DefaultErrorPolicies {
+		c.ErrorPolicies[category.String()] = policy.String()
+	}
+	for category, policy := range p.ErrorPolicies {
+		c.ErrorPolicies[category.String()] = policy.String()
+	}
+	if p.ByteBudgetPerWindow > 0 {
+		c.ByteBudgetPerWindow = p.ByteBudgetPerWindow
+		c.ByteBudgetWindow = p.byteBudgetWindow().String()
+		c.DropOverBudget = p.DropOverBudget
+	}
+	if c.PauseBufferSize <= 0 {
+		c.PauseBufferSize = defaultPauseBufferSize
+	}
+	if p.SamplingUnderPressure {
+		c.SamplingHighWater = p.samplingHighWater()
+		c.SamplingAfter = p.samplingAfter().String()
+		c.SamplingRate = p.samplingRate()
+	}
+	if p.DedupTTL > 0 {
+		c.DedupTTL = p.DedupTTL.String()
+		c.DedupCacheSize = p.DedupCacheSize
+		if c.DedupCacheSize <= 0 {
+			c.DedupCacheSize = defaultDedupCacheSize
+		}
+	}
+	if p.RetentionBound {
+		c.RetentionRefreshInterval = defaultIfZero(p.RetentionRefreshInterval, defaultRetentionRefreshInterval).String()
+	}
+	if p.DroppedMetricService != nil {
+		c.DroppedMetricInterval = defaultIfZero(p.DroppedMetricInterval, defaultDroppedMetricInterval).String()
+	}
+	for _, f := range p.MetricFilters {
+		c.MetricFilters = append(c.MetricFilters, f.Name)
+	}
+	if p.ValidateExistingStream {
+		c.StaleStreamAge = defaultIfZero(p.StaleStreamAge, defaultStaleStreamAge).String()
+	}
+	if c.MaxStreams <= 0 {
+		c.MaxStreams = defaultMaxStreams
+	}
+	c.StreamIdleTimeout = defaultIfZero(p.StreamIdleTimeout, defaultStreamIdleTimeout).String()
+	if p.sharded() {
+		c.ShardCount = p.ShardCount
+	}
+	if p.EnvelopeFormat != nil {
+		e := *p.EnvelopeFormat
+		e.Fields = maskSensitive(e.Fields)
+		c.EnvelopeFormat = &e
+	}
+	if p.HeartbeatInterval > 0 {
+		c.HeartbeatInterval = p.HeartbeatInterval.String()
+		c.HeartbeatMessage = p.HeartbeatMessage
+		if c.HeartbeatMessage == "" {
+			c.HeartbeatMessage = defaultHeartbeatMessage
+		}
+	}
+	if p.SinkStrategy == Tee {
+		c.TeeDurability = p.TeeDurability.String()
+	}
+	if p.Entity != nil {
+		c.Entity = map[string]map[string]string{
+			"key_attributes": maskSensitivePtr(p.Entity.KeyAttributes),
+			"attributes":     maskSensitivePtr(p.Entity.Attributes),
+		}
+	}
+	c.Hooks = p.hooks()
+	return c
+}
+
+// hooks returns the names of the function, writer and sink options set on the pusher, sorted.
+func (p *pusher) hooks() []string {
+	set := map[string]bool{
+		"TruncateMarker":     p.TruncateMarker != nil,
+		"DeadLetter":         p.DeadLetter != nil,
+		"PostConvert":        p.PostConvert != nil,
+		"OnSequenceToken":    p.OnSequenceToken != nil,
+		"OnReject":           p.OnReject != nil,
+		"OnStreamAnomaly":    p.OnStreamAnomaly != nil,
+		"MirrorWriter":       p.MirrorWriter != nil,
+		"AuditWriter":        p.AuditWriter != nil,
+		"ArchiveSink":        p.ArchiveSink != nil,
+		"OnBatchChecksum":    p.OnBatchChecksum != nil,
+		"OnDelivered":        p.OnDelivered != nil,
+		"OnSendAttempt":      p.OnSendAttempt != nil,
+		"Decoder":            p.Decoder != nil,
+		"Redactor":           p.Redactor != nil,
+		"FrameKey":           p.FrameKey != nil,
+		"MetadataProvider":   p.MetadataProvider != nil,
+		"RouteFn":            p.RouteFn != nil,
+		"StreamFlushTimeout": p.StreamFlushTimeout != nil,
+		"ShardKeyFn":         p.ShardKeyFn != nil,
+		"PartitionFn":        p.PartitionFn != nil,
+	}
+	var hooks []string
+	for name, ok := range set {
+		if ok {
+			hooks = append(hooks, name)
+		}
+	}
+	sort.Strings(hooks)
+	return hooks
+}
+
+// optionalDuration formats the duration of an option disabled when 0, empty when it is.
+func optionalDuration(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	return d.String()
+}
+
+func defaultIfZero(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
+
+// maskSensitive copies the fields, masking the values of the sensitive keys.
+func maskSensitive(fields map[string]string) map[string]string {
+	if fields == nil {
+		return nil
+	}
+	masked := make(map[string]string, len(fields))
+	for k, v := range fields {
+		if sensitiveKeyRegex.MatchString(k) {
+			v = maskedValue
+		}
+		masked[k] = v
+	}
+	return masked
+}
+
+func maskSensitivePtr(attributes map[string]*string) map[string]string {
+	fields := make(map[string]string, len(attributes))
+	for k, v := range attributes {
+		if v != nil {
+			fields[k] = *v
+		}
+	}
+	return maskSensitive(fields)
+}
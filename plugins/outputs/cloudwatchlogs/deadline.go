@@ -0,0 +1,132 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/amazon-cloudwatch-agent/logs"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+var errBatchDeadlineExceeded = errors.New("batch deadline exceeded")
+
+// DeadlineLogEvent is a log event carrying the deadline of the caller which added it, such as the events
+// added with AddEventCtx. The earliest deadline of the events of a batch bounds the PutLogEvents requests
+// sending it, and the events are dropped rather than retried once their own deadline passed.
+type DeadlineLogEvent interface {
+	logs.LogEvent
+	Deadline() (time.Time, bool)
+}
+
+// deadlineLogEvent is a log event added with the deadline of its context.
+type deadlineLogEvent struct {
+	wrappedLogEvent
+	deadline time.Time
+}
+
+func (e *deadlineLogEvent) Deadline() (time.Time, bool) { return e.deadline, true }
+
+// eventDeadline returns the deadline of the event, through the wrappers of the pusher.
+func eventDeadline(e logs.LogEvent) (time.Time, bool) {
+	if de, ok := e.(DeadlineLogEvent); ok {
+		return de.Deadline()
+	}
+	return time.Time{}, false
+}
+
+// AddEventCtx adds the event like AddEvent, with the deadline of ctx, if any, bounding the requests sending
+// its batch: a request still in flight at the deadline is cancelled, and the event is dead-lettered once the
+// deadline passed rather than retried, the other events of its batch being retried. The deadline of a partial
+// event is ignored.
+func (p *pusher) AddEventCtx(ctx context.Context, e logs.LogEvent) {
+	if d, ok := ctx.Deadline(); ok {
+		if _, partial := e.(PartialLogEvent); !partial {
+			e = &deadlineLogEvent{wrappedLogEvent{e}, d}
+		}
+	}
+	p.AddEvent(e)
+}
+
+// trackDeadline records the deadline of the event added to the batch as ce, and keeps the earliest deadline
+// of the events of the batch.
+func (p *pusher) trackDeadline(ce *cloudwatchlogs.InputLogEvent, e logs.LogEvent) {
+	d, ok := eventDeadline(e)
+	if !ok {
+		return
+	}
+	if p.eventDeadlines == nil {
+		p.eventDeadlines = make(map[*cloudwatchlogs.InputLogEvent]time.Time)
+	}
+	p.eventDeadlines[ce] = d
+	if p.batchDeadline.IsZero() || d.Before(p.batchDeadline) {
+		p.batchDeadline = d
+	}
+}
+
+// earliestDeadline returns the earliest deadline of the events, zero when none has one.
+func (p *pusher) earliestDeadline(events []*cloudwatchlogs.InputLogEvent) time.Time {
+	var earliest time.Time
+	if len(p.eventDeadlines) == 0 {
+		return earliest
+	}
+	for _, ce := range events {
+		if d, ok := p.eventDeadlines[ce]; ok && (earliest.IsZero() || d.Before(earliest)) {
+			earliest = d
+		}
+	}
+	return earliest
+}
+
+// releaseDeadlines forgets the deadlines of the batched events once they are delivered or dropped.
+func (p *pusher) releaseDeadlines(events []*cloudwatchlogs.InputLogEvent) {
+	if len(p.eventDeadlines) == 0 {
+		return
+	}
+	for _, ce := range events {
+		delete(p.eventDeadlines, ce)
+	}
+}
+
+// expireDeadlines dead-letters the events of the batch whose deadline is before at, and keeps the others
+// in the batch. It returns whether none is left.
+func (p *pusher) expireDeadlines(b *logBatch, at time.Time) bool {
+	var events, expired []*cloudwatchlogs.InputLogEvent
+	var dones []func()
+	size := 0
+	for i, ce := range b.events {
+		if d, ok := p.eventDeadlines[ce]; ok && d.Before(at) {
+			expired = append(expired, ce)
+			continue
+		}
+		events = append(events, ce)
+		dones = append(dones, b.dones[i])
+		size += len(*ce.Message) + p.eventHeaderSize()
+	}
+	if len(expired) > 0 {
+		p.Log.Errorf("%v log events to %v/%v not delivered before their deadline after %v retries, dropped: %v", len(expired), p.Group, p.Stream, b.retryCount, b.lastErr)
+		p.deadLetter(expired, errBatchDeadlineExceeded)
+	}
+	b.events, b.dones, b.size = events, dones, size
+	b.deadline = p.earliestDeadline(events)
+	return len(events) == 0
+}
+
+// contextService is implemented by the services able to bound a PutLogEvents request with a context,
+// such as the SDK client.
+type contextService interface {
+	PutLogEventsWithContext(ctx aws.Context, input *cloudwatchlogs.PutLogEventsInput, opts ...request.Option) (*cloudwatchlogs.PutLogEventsOutput, error)
+}
+
+// requestContext returns the context of a request of a batch, bounded by its deadline when it has one.
+func requestContext(deadline time.Time) (context.Context, context.CancelFunc) {
+	if deadline.IsZero() {
+		return context.Background(), func() {}
+	}
+	return context.WithDeadline(context.Background(), deadline)
+}
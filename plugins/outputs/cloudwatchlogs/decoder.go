@@ -0,0 +1,35 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// DecoderForEncoding returns a Decoder transcoding from the named encoding, such as "latin1" or
+// "windows-1252", to UTF-8.
+func DecoderForEncoding(name string) (func([]byte) string, error) {
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported encoding %q: %w", name, err)
+	}
+	return func(b []byte) string {
+		decoded, err := enc.NewDecoder().Bytes(b)
+		if err != nil {
+			return string(b)
+		}
+		return string(decoded)
+	}, nil
+}
+
+// decode transcodes the message with the Decoder when it is not valid UTF-8.
+func (p *pusher) decode(message string) string {
+	if p.Decoder == nil || utf8.ValidString(message) {
+		return message
+	}
+	return p.Decoder([]byte(message))
+}
@@ -0,0 +1,74 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"container/list"
+	"hash/fnv"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/amazon-cloudwatch-agent/logs"
+)
+
+const defaultDedupCacheSize = 10000
+
+// dedupCache is a bounded LRU of the hashes of the recently sent messages, used by the event loop only.
+type dedupCache struct {
+	entries map[uint64]*list.Element
+	lru     *list.List // most recently seen first
+}
+
+type dedupEntry struct {
+	hash     uint64
+	sentTime time.Time
+}
+
+// isDuplicate reports whether the message of the event was sent less than DedupTTL ago, and records
+// it as sent otherwise. The least recently seen message is forgotten once DedupCacheSize are cached,
+// or once it was sent DedupTTL ago, as it no longer makes a duplicate.
+func (p *pusher) isDuplicate(e logs.LogEvent) bool {
+	if p.dedup == nil {
+		p.dedup = &dedupCache{entries: make(map[uint64]*list.Element), lru: list.New()}
+	}
+	c := p.dedup
+	h := fnv.New64a()
+	h.Write([]byte(e.Message()))
+	hash := h.Sum64()
+	now := time.Now()
+
+	if el, ok := c.entries[hash]; ok {
+		c.lru.MoveToFront(el)
+		entry := el.Value.(*dedupEntry)
+		if now.Sub(entry.sentTime) < p.DedupTTL {
+			return true
+		}
+		entry.sentTime = now
+		return false
+	}
+
+	c.entries[hash] = c.lru.PushFront(&dedupEntry{hash: hash, sentTime: now})
+	size := p.DedupCacheSize
+	if size <= 0 {
+		size = defaultDedupCacheSize
+	}
+	for c.lru.Len() > size || now.Sub(c.lru.Back().Value.(*dedupEntry).sentTime) >= p.DedupTTL {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*dedupEntry).hash)
+	}
+	atomic.StoreInt64(&p.counters.dedupEntries, int64(c.lru.Len()))
+	return false
+}
+
+// dropDuplicate drops the event when DedupTTL is set and its message is a duplicate, and reports whether it did.
+// The event is done, as its message was already sent.
+func (p *pusher) dropDuplicate(e logs.LogEvent) bool {
+	if p.DedupTTL <= 0 || !p.isDuplicate(e) {
+		return false
+	}
+	p.counters.addDuplicate()
+	p.dropped(e)
+	return true
+}
@@ -0,0 +1,51 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"sync/atomic"
+
+	"github.com/aws/amazon-cloudwatch-agent/logs"
+)
+
+// trackedLogEvent is a log event of a caller batch, done through the batch delivery tracking.
+type trackedLogEvent struct {
+	wrappedLogEvent
+	done func()
+}
+
+func (e *trackedLogEvent) Done() { e.done() }
+
+// trackedPartialLogEvent keeps the partial events recognizable once tracked.
+type trackedPartialLogEvent struct {
+	wrappedPartialLogEvent
+	done func()
+}
+
+func (e *trackedPartialLogEvent) Done() { e.done() }
+
+// AddEventsWithID adds the events of a caller batch, and calls OnDelivered with the batch ID once all of
+// them are delivered, whether in one or several PutLogEvents requests. OnDelivered is not called when
+// any of the events is dropped.
+func (p *pusher) AddEventsWithID(batchID string, events []logs.LogEvent) {
+	remaining := int32(len(events))
+	for _, e := range events {
+		e := e
+		done := func() {
+			e.Done()
+			if atomic.AddInt32(&remaining, -1) == 0 && p.OnDelivered != nil {
+				p.OnDelivered(batchID, len(events))
+			}
+		}
+		p.AddEvent(withDone(e, done))
+	}
+}
+
+// withDone returns the event with its Done replaced by done, keeping partial events recognizable.
+func withDone(e logs.LogEvent, done func()) logs.LogEvent {
+	if pe, ok := e.(PartialLogEvent); ok {
+		return &trackedPartialLogEvent{wrapPartial(pe), done}
+	}
+	return &trackedLogEvent{wrappedLogEvent{e}, done}
+}
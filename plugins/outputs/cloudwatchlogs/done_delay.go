@@ -0,0 +1,59 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"time"
+)
+
+// delayedDones are the done callbacks of a delivered batch, to be called once DoneCallbackDelay elapsed.
+type delayedDones struct {
+	due   time.Time
+	dones []func()
+}
+
+// callDones calls the done callbacks of delivered events, in reverse order, or schedules them after
+// DoneCallbackDelay. The scheduled callbacks are called by the send loop, in the order of the deliveries.
+// They are skipped during the shutdown flush with SuppressDoneOnShutdown.
+func (p *pusher) callDones(dones []func()) {
+	if p.suppressDones {
+		return
+	}
+	if p.DoneCallbackDelay <= 0 {
+		for i := len(dones) - 1; i >= 0; i-- {
+			dones[i]()
+		}
+		return
+	}
+	if len(dones) == 0 {
+		return
+	}
+	// The slice of the batch is reused by the next one, keep a copy
+	dones = append(make([]func(), 0, len(dones)), dones...)
+	p.delayedDones = append(p.delayedDones, delayedDones{due: time.Now().Add(p.DoneCallbackDelay), dones: dones})
+	if len(p.delayedDones) == 1 {
+		p.doneTimer.Reset(p.DoneCallbackDelay)
+	}
+}
+
+// callDelayedDones calls the delayed done callbacks which are due, or all of them when the pusher stops
+// or is drained.
+func (p *pusher) callDelayedDones(all bool) {
+	now := time.Now()
+	for len(p.delayedDones) > 0 && (all || !p.delayedDones[0].due.After(now)) {
+		dones := p.delayedDones[0].dones
+		p.delayedDones[0] = delayedDones{}
+		p.delayedDones = p.delayedDones[1:]
+		if p.suppressDones {
+			continue
+		}
+		for i := len(dones) - 1; i >= 0; i-- {
+			dones[i]()
+		}
+	}
+	p.doneTimer.Stop()
+	if len(p.delayedDones) > 0 {
+		p.doneTimer.Reset(time.Until(p.delayedDones[0].due))
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"context"
+	"time"
+)
+
+// Drain sends the events added with AddEvent before it is called and waits until they are delivered,
+// dropped or dead-lettered, or until ctx is done or the pusher stopped. The events added while it runs
+// are neither dropped nor waited for: they are batched after the drained ones and sent on the flush timer
+// as usual, so Drain returns even when events keep coming, unlike WaitEmpty. The point in time is a marker
+// queued behind the events already added, the events added with AddEventNonBlocking are not ordered with
+// it, and a partial event still waiting for its continuation is not sent. The batches held while paused
+// are waited for until Resume, and those held over the byte budget until the window rolls. The done
+// callbacks delayed by DoneCallbackDelay are called before it returns.
+func (p *pusher) Drain(ctx context.Context) error {
+	// The shards and streams are drained first, their sends are the events of the pusher
+	if p.sharded() {
+		for _, s := range p.shardPushers() {
+			if err := s.Drain(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	for _, s := range p.streamPushers() {
+		if err := s.Drain(ctx); err != nil {
+			return err
+		}
+	}
+
+	// The batches of the events before the marker not delivered yet once the current batch is sent
+	var pending []*logBatch
+	marked := make(chan struct{})
+	marker := &controlEvent{func() {
+		if len(p.events) > 0 {
+			p.send()
+		}
+		pending = append(pending, p.retryQueue...)
+		pending = append(pending, p.budgetHeld...)
+		pending = append(pending, p.pausedBatches...)
+		close(marked)
+	}}
+	select {
+	case p.eventsCh <- marker:
+	case <-p.stop:
+		return errPusherStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-marked:
+	case <-p.stop:
+		return errPusherStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	for len(pending) > 0 {
+		t := time.NewTimer(waitEmptyInterval)
+		select {
+		case <-t.C:
+		case <-p.stop:
+			t.Stop()
+			return errPusherStopped
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+		if err := p.controlWait(func() { pending = p.stillPending(pending) }); err != nil {
+			return err
+		}
+	}
+	// The done callbacks of the delivered events are not left waiting for DoneCallbackDelay
+	return p.controlWait(func() { p.callDelayedDones(true) })
+}
+
+// stillPending returns the batches still waiting for a retry, held over the byte budget or held while paused.
+func (p *pusher) stillPending(batches []*logBatch) []*logBatch {
+	queued := make(map[*logBatch]bool, len(p.retryQueue)+len(p.budgetHeld)+len(p.pausedBatches))
+	for _, b := range p.retryQueue {
+		queued[b] = true
+	}
+	for _, b := range p.budgetHeld {
+		queued[b] = true
+	}
+	for _, b := range p.pausedBatches {
+		queued[b] = true
+	}
+	remaining := batches[:0]
+	for _, b := range batches {
+		if queued[b] {
+			remaining = append(remaining, b)
+		}
+	}
+	return remaining
+}
@@ -0,0 +1,43 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"fmt"
+
+	"github.com/aws/amazon-cloudwatch-agent/logs"
+)
+
+// DroppedEventDonePolicy is whether the done callback of the events the pusher drops on purpose is called:
+// the events out of the accepted time range, sampled out, duplicated or flush markers dropped.
+type DroppedEventDonePolicy int
+
+const (
+	// CallDone calls the done callback of the dropped events, so their source moves past them as if they
+	// were delivered: they are delivered at most once.
+	CallDone DroppedEventDonePolicy = iota
+	// SkipDone does not call the done callback of the dropped events, like for the events which could not
+	// be delivered, so a source committing its offsets on it reads them again after a restart: they are
+	// delivered at least once, if they are no longer dropped.
+	SkipDone
+)
+
+func (d DroppedEventDonePolicy) String() string {
+	switch d {
+	case CallDone:
+		return "CallDone"
+	case SkipDone:
+		return "SkipDone"
+	}
+	return fmt.Sprintf("DroppedEventDonePolicy(%d)", int(d))
+}
+
+// dropped ends an event dropped on purpose according to the DroppedEventDonePolicy. Its WAL entries are
+// removed either way, it would be dropped again when replayed.
+func (p *pusher) dropped(e logs.LogEvent) {
+	p.releaseDroppedWAL(e)
+	if p.DroppedEventDonePolicy == CallDone {
+		e.Done()
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+const (
+	droppedMetricNamespace       = "CWAgent"
+	droppedMetricName            = "DroppedLogEvents"
+	defaultDroppedMetricInterval = time.Minute
+)
+
+// CloudWatchMetricsService is the part of the CloudWatch client used to publish the pusher metrics.
+type CloudWatchMetricsService interface {
+	PutMetricData(input *cloudwatch.PutMetricDataInput) (*cloudwatch.PutMetricDataOutput, error)
+}
+
+// startDroppedMetric starts publishing the dropped log events with the DroppedMetricService, once the first
+// event is added. The dimensions are the target of the pusher at that time.
+func (p *pusher) startDroppedMetric() {
+	if p.DroppedMetricService == nil {
+		return
+	}
+	p.droppedMetricOnce.Do(func() { p.publishDroppedMetric(p.DroppedMetricService, p.DroppedMetricInterval) })
+}
+
+// publishDroppedMetric publishes the number of log events dropped by the pusher as the DroppedLogEvents
+// CloudWatch metric, dimensioned by log group and stream, at most once per interval (a minute when 0)
+// and only when events were dropped since the last publish. It runs apart from the send loop, a failure
+// to publish is only logged and never delays the log events.
+func (p *pusher) publishDroppedMetric(svc CloudWatchMetricsService, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultDroppedMetricInterval
+	}
+	t := p.target()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var published int64
+		for {
+			select {
+			case <-ticker.C:
+				dropped := p.Stats().EventsDropped
+				if dropped == published {
+					continue
+				}
+				if err := putDroppedMetric(svc, t, dropped-published); err != nil {
+					p.Log.Warnf("Unable to publish the %v metric for %v/%v: %v", droppedMetricName, t.Group, t.Stream, err)
+					continue
+				}
+				published = dropped
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+func putDroppedMetric(svc CloudWatchMetricsService, t Target, dropped int64) error {
+	_, err := svc.PutMetricData(&cloudwatch.PutMetricDataInput{
+		Namespace: aws.String(droppedMetricNamespace),
+		MetricData: []*cloudwatch.MetricDatum{{
+			MetricName: aws.String(droppedMetricName),
+			Dimensions: []*cloudwatch.Dimension{
+				{Name: aws.String("LogGroupName"), Value: aws.String(t.Group)},
+				{Name: aws.String("LogStreamName"), Value: aws.String(t.Stream)},
+			},
+			Timestamp: aws.Time(time.Now()),
+			Unit:      aws.String(cloudwatch.StandardUnitCount),
+			Value:     aws.Float64(float64(dropped)),
+		}},
+	})
+	return err
+}
@@ -0,0 +1,29 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Endpoint returns the region and URL of the CloudWatch Logs endpoint the pusher sends to, as configured
+// in its SDK client. They are empty when the service is not an SDK client, such as the stdout service.
+func (p *pusher) Endpoint() (region, url string) {
+	s, ok := p.Service.(*logsClient)
+	if !ok || s.CloudWatchLogs == nil || s.Client == nil {
+		return "", ""
+	}
+	return aws.StringValue(s.Config.Region), s.Endpoint
+}
+
+// destination describes the log group and stream of the pusher, with its endpoint when known, for the logs.
+func (p *pusher) destination() string {
+	region, url := p.Endpoint()
+	if url == "" {
+		return fmt.Sprintf("%v/%v", p.Group, p.Stream)
+	}
+	return fmt.Sprintf("%v/%v (region %v, endpoint %v)", p.Group, p.Stream, region, url)
+}
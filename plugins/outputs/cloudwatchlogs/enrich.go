@@ -0,0 +1,199 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// The metadata fields available to EnrichFields.
+const (
+	MetadataInstanceID       = "instance_id"
+	MetadataInstanceType     = "instance_type"
+	MetadataAvailabilityZone = "availability_zone"
+	MetadataRegion           = "region"
+	MetadataECSCluster       = "ecs_cluster"
+	MetadataECSTaskARN       = "ecs_task_arn"
+)
+
+const (
+	metadataTimeout        = time.Second
+	ecsMetadataEndpointEnv = "ECS_CONTAINER_METADATA_URI"
+)
+
+// EC2ECSMetadata fetches the metadata fields of the EC2 instance from IMDS and, when running in an ECS task,
+// of the task from the ECS metadata endpoint. It fails only when neither is available.
+func EC2ECSMetadata() (map[string]string, error) {
+	md := make(map[string]string)
+	client := &http.Client{Timeout: metadataTimeout}
+
+	if ses, err := session.NewSession(&aws.Config{HTTPClient: client, MaxRetries: aws.Int(0)}); err == nil {
+		if doc, err := ec2metadata.New(ses).GetInstanceIdentityDocument(); err == nil {
+			md[MetadataInstanceID] = doc.InstanceID
+			md[MetadataInstanceType] = doc.InstanceType
+			md[MetadataAvailabilityZone] = doc.AvailabilityZone
+			md[MetadataRegion] = doc.Region
+		}
+	}
+
+	if endpoint, ok := os.LookupEnv(ecsMetadataEndpointEnv); ok {
+		if resp, err := client.Get(endpoint + "/task"); err == nil {
+			var task struct {
+				Cluster          string
+				TaskARN          string
+				AvailabilityZone string
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err == nil && json.Unmarshal(body, &task) == nil {
+				md[MetadataECSCluster] = task.Cluster
+				md[MetadataECSTaskARN] = task.TaskARN
+				if task.AvailabilityZone != "" {
+					md[MetadataAvailabilityZone] = task.AvailabilityZone
+				}
+			}
+		}
+	}
+
+	if len(md) == 0 {
+		return nil, errors.New("neither EC2 nor ECS metadata is available")
+	}
+	return md, nil
+}
+
+// metadataCache fetches the metadata of a provider once, in the background, for the pushers sharing it.
+type metadataCache struct {
+	provider func() (map[string]string, error)
+	once     sync.Once
+	fetched  chan struct{}
+	md       map[string]string
+	err      error
+}
+
+func newMetadataCache(provider func() (map[string]string, error)) *metadataCache {
+	return &metadataCache{provider: provider, fetched: make(chan struct{})}
+}
+
+// ec2ECSMetadata is the metadata of EC2ECSMetadata, fetched once per process.
+var ec2ECSMetadata = newMetadataCache(EC2ECSMetadata)
+
+// fetch starts fetching the metadata, unless it already started.
+func (c *metadataCache) fetch() {
+	c.once.Do(func() {
+		go func() {
+			c.md, c.err = c.provider()
+			close(c.fetched)
+		}()
+	})
+}
+
+// get returns the metadata, waiting for the fetch in progress.
+func (c *metadataCache) get() (map[string]string, error) {
+	c.fetch()
+	<-c.fetched
+	return c.md, c.err
+}
+
+// metadataSource returns the cache of the MetadataProvider, shared with the shards and stream pushers,
+// or the one of EC2ECSMetadata, shared by all the pushers.
+func (p *pusher) metadataSource() *metadataCache {
+	if p.MetadataProvider == nil {
+		return ec2ECSMetadata
+	}
+	p.metadataOnce.Do(func() {
+		if p.providerMetadata == nil {
+			p.providerMetadata = newMetadataCache(p.MetadataProvider)
+		}
+	})
+	return p.providerMetadata
+}
+
+// prefetchMetadata starts fetching the metadata of EnrichFields as the events are added, so the send loop
+// does not wait for it unless the fetch is still in progress when the first event is converted.
+func (p *pusher) prefetchMetadata() {
+	if len(p.EnrichFields) > 0 {
+		p.metadataSource().fetch()
+	}
+}
+
+// enrichment returns the EnrichFields of the metadata, fetched on first use. Without metadata, a warning
+// is logged once and the messages are no longer enriched.
+func (p *pusher) enrichment() []metadataField {
+	if !p.metadataFetched {
+		p.metadataFetched = true
+		md, err := p.metadataSource().get()
+		if err != nil {
+			p.Log.Warnf("Unable to fetch the metadata to enrich the log events of %v/%v, sending them as is: %v", p.Group, p.Stream, err)
+		}
+		for _, f := range p.EnrichFields {
+			if v, ok := md[f]; ok && v != "" {
+				p.metadata = append(p.metadata, metadataField{f, v})
+			}
+		}
+	}
+	return p.metadata
+}
+
+type metadataField struct {
+	key, value string
+}
+
+// enrich adds the metadata fields absent from a JSON object message, after its own fields.
+// Other messages are prefixed with the fields as key=value pairs.
+func (p *pusher) enrich(message string) string {
+	fields := p.enrichment()
+	if len(fields) == 0 {
+		return message
+	}
+	return addFields(message, fields)
+}
+
+// addFields adds the fields absent from a JSON object message, after its own fields.
+// Other messages are prefixed with the fields as key=value pairs.
+func addFields(message string, fields []metadataField) string {
+	trimmed := strings.TrimSpace(message)
+	var obj map[string]json.RawMessage
+	if strings.HasPrefix(trimmed, "{") && json.Unmarshal([]byte(trimmed), &obj) == nil {
+		var sb strings.Builder
+		sb.WriteString(strings.TrimSuffix(trimmed, "}"))
+		n := len(obj)
+		for _, f := range fields {
+			if _, ok := obj[f.key]; ok {
+				continue
+			}
+			if n > 0 {
+				sb.WriteByte(',')
+			}
+			n++
+			k, _ := json.Marshal(f.key)
+			v, _ := json.Marshal(f.value)
+			sb.Write(k)
+			sb.WriteByte(':')
+			sb.Write(v)
+		}
+		sb.WriteByte('}')
+		return sb.String()
+	}
+
+	var sb strings.Builder
+	for _, f := range fields {
+		sb.WriteString(f.key)
+		sb.WriteByte('=')
+		sb.WriteString(f.value)
+		sb.WriteByte(' ')
+	}
+	sb.WriteString(message)
+	return sb.String()
+}
@@ -0,0 +1,127 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+const opPutLogEvents = "PutLogEvents"
+
+// Entity is the entity parameter of PutLogEvents, which the vendored SDK predates. It associates the
+// log events with the service or resource which produced them, such as the KeyAttributes Type "Service",
+// Name and Environment, so CloudWatch can relate them to its other telemetry.
+type Entity struct {
+	_ struct{} `type:"structure"`
+
+	KeyAttributes map[string]*string `locationName:"keyAttributes" min:"2" type:"map"`
+	Attributes    map[string]*string `locationName:"attributes" type:"map"`
+}
+
+// Validate checks the entity against the constraints of the PutLogEvents API: 2 to 4 key attributes with
+// keys up to 32 characters, up to 10 attributes with keys up to 256 characters, and values of 1 to
+// 512 characters.
+func (e *Entity) Validate() error {
+	invalidParams := request.ErrInvalidParams{Context: "Entity"}
+	if n := len(e.KeyAttributes); n < 2 {
+		invalidParams.Add(request.NewErrParamMinLen("KeyAttributes", 2))
+	} else if n > 4 {
+		invalidParams.Add(request.NewErrParamMaxLen("KeyAttributes", 4, fmt.Sprint(n)))
+	}
+	validateAttributes(&invalidParams, "KeyAttributes", e.KeyAttributes, 32)
+	if n := len(e.Attributes); n > 10 {
+		invalidParams.Add(request.NewErrParamMaxLen("Attributes", 10, fmt.Sprint(n)))
+	}
+	validateAttributes(&invalidParams, "Attributes", e.Attributes, 256)
+	if invalidParams.Len() > 0 {
+		return invalidParams
+	}
+	return nil
+}
+
+func validateAttributes(invalidParams *request.ErrInvalidParams, field string, attrs map[string]*string, maxKeyLen int) {
+	for k, v := range attrs {
+		if k == "" {
+			invalidParams.Add(request.NewErrParamMinLen(field+" key", 1))
+		} else if len(k) > maxKeyLen {
+			invalidParams.Add(request.NewErrParamMaxLen(field+" key", maxKeyLen, k))
+		}
+		name := fmt.Sprintf("%v[%v]", field, k)
+		if v == nil || *v == "" {
+			invalidParams.Add(request.NewErrParamMinLen(name, 1))
+		} else if len(*v) > 512 {
+			invalidParams.Add(request.NewErrParamMaxLen(name, 512, *v))
+		}
+	}
+}
+
+// putLogEventsWithEntityInput is the PutLogEvents input with the entity parameter.
+type putLogEventsWithEntityInput struct {
+	_ struct{} `type:"structure"`
+
+	Entity        *Entity                         `locationName:"entity" type:"structure"`
+	LogEvents     []*cloudwatchlogs.InputLogEvent `locationName:"logEvents" min:"1" type:"list" required:"true"`
+	LogGroupName  *string                         `locationName:"logGroupName" min:"1" type:"string" required:"true"`
+	LogStreamName *string                         `locationName:"logStreamName" min:"1" type:"string" required:"true"`
+	SequenceToken *string                         `locationName:"sequenceToken" min:"1" type:"string"`
+}
+
+// entityService is implemented by the services able to send the entity with the log events.
+type entityService interface {
+	PutLogEventsWithEntity(ctx aws.Context, input *cloudwatchlogs.PutLogEventsInput, entity *Entity) (*cloudwatchlogs.PutLogEventsOutput, error)
+}
+
+func (c *logsClient) PutLogEventsWithEntity(ctx aws.Context, input *cloudwatchlogs.PutLogEventsInput, entity *Entity) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	op := &request.Operation{
+		Name:       opPutLogEvents,
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+	output := &cloudwatchlogs.PutLogEventsOutput{}
+	req := c.NewRequest(op, &putLogEventsWithEntityInput{
+		Entity:        entity,
+		LogEvents:     input.LogEvents,
+		LogGroupName:  input.LogGroupName,
+		LogStreamName: input.LogStreamName,
+		SequenceToken: input.SequenceToken,
+	}, output)
+	req.SetContext(ctx)
+	return output, req.Send()
+}
+
+// putLogEventsWithEntity sends the input with the Entity when it is set, valid and supported by the service.
+// An invalid or unsupported entity is logged once and omitted, the log events are still sent. The request
+// is cancelled at the deadline, when not zero and supported by the service.
+func (p *pusher) putLogEventsWithEntity(deadline time.Time, input *cloudwatchlogs.PutLogEventsInput) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	ctx, cancel := requestContext(deadline)
+	defer cancel()
+	if p.Entity == nil {
+		if cs, ok := p.Service.(contextService); ok && !deadline.IsZero() {
+			return cs.PutLogEventsWithContext(ctx, input)
+		}
+		return p.Service.PutLogEvents(input)
+	}
+	s, ok := p.Service.(entityService)
+	if !p.entityChecked {
+		p.entityChecked = true
+		if err := p.Entity.Validate(); err != nil {
+			p.Log.Errorf("Invalid entity for %v/%v, sending the log events without it: %v", p.Group, p.Stream, err)
+			p.entityInvalid = true
+		} else if !ok {
+			p.Log.Warnf("The service of %v/%v does not support entities, sending the log events without it", p.Group, p.Stream)
+		}
+	}
+	if !ok || p.entityInvalid {
+		if cs, ok := p.Service.(contextService); ok && !deadline.IsZero() {
+			return cs.PutLogEventsWithContext(ctx, input)
+		}
+		return p.Service.PutLogEvents(input)
+	}
+	return s.PutLogEventsWithEntity(ctx, input, p.Entity)
+}
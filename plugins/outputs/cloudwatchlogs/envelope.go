@@ -0,0 +1,81 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// omitEnvelopeKey is the key omitting a field of the envelope.
+const omitEnvelopeKey = "-"
+
+// EnvelopeFormat is the schema of the JSON object the plain messages are wrapped into, so the subscribers
+// of the log group, such as a Lambda function or a Kinesis stream, parse all of them the same way.
+type EnvelopeFormat struct {
+	// TimestampKey, MessageKey and HostKey are the keys of the event time in RFC3339Nano, of the message and
+	// of the host name, "@timestamp", "message" and "host" when empty. The field of a key "-" is omitted.
+	TimestampKey string
+	MessageKey   string
+	HostKey      string
+	// Fields are added to each envelope after them, in the order of their keys, such as the service name.
+	Fields map[string]string
+	// MergeJSON adds the timestamp, host and Fields to the messages which are already JSON objects, unless they
+	// have them, instead of passing these messages through unchanged.
+	MergeJSON bool
+}
+
+func envelopeKey(key, def string) string {
+	if key == "" {
+		return def
+	}
+	return key
+}
+
+// wrapEnvelope wraps the message into the EnvelopeFormat, or merges the envelope fields into a JSON object.
+func (p *pusher) wrapEnvelope(message string, t time.Time) string {
+	f := p.EnvelopeFormat
+	isJSON := false
+	trimmed := strings.TrimSpace(message)
+	if strings.HasPrefix(trimmed, "{") {
+		var obj map[string]json.RawMessage
+		isJSON = json.Unmarshal([]byte(trimmed), &obj) == nil
+	}
+	if isJSON && !f.MergeJSON {
+		return message
+	}
+
+	var fields []metadataField
+	if key := envelopeKey(f.TimestampKey, "@timestamp"); key != omitEnvelopeKey {
+		if t.IsZero() {
+			t = time.Now()
+		}
+		fields = append(fields, metadataField{key, t.UTC().Format(time.RFC3339Nano)})
+	}
+	if key := envelopeKey(f.MessageKey, "message"); key != omitEnvelopeKey && !isJSON {
+		fields = append(fields, metadataField{key, message})
+	}
+	if key := envelopeKey(f.HostKey, "host"); key != omitEnvelopeKey {
+		if p.hostname == "" {
+			p.hostname, _ = os.Hostname()
+		}
+		fields = append(fields, metadataField{key, p.hostname})
+	}
+	keys := make([]string, 0, len(f.Fields))
+	for k := range f.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fields = append(fields, metadataField{k, f.Fields[k]})
+	}
+
+	if isJSON {
+		return addFields(message, fields)
+	}
+	return addFields("{}", fields)
+}
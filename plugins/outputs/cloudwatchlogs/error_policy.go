@@ -0,0 +1,151 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// ErrorCategory is the category of an AWS error failing a PutLogEvents request, which ErrorPolicies
+// maps to the DropPolicy handling it.
+type ErrorCategory int
+
+const (
+	// ThrottlingError is a request rate limited by CloudWatch Logs.
+	ThrottlingError ErrorCategory = iota
+	// AccessDeniedError is a request the credentials are not authorized for.
+	AccessDeniedError
+	// InvalidParameterError is a request CloudWatch Logs rejects for its content, usually some of its events.
+	InvalidParameterError
+	// UnknownError is any other AWS error without a dedicated handling.
+	UnknownError
+	// ConcurrentWriterError is a batch whose sequence token was rejected more than MaxSequenceTokenRetries
+	// times, as another writer keeps invalidating it.
+	ConcurrentWriterError
+)
+
+func (c ErrorCategory) String() string {
+	switch c {
+	case ThrottlingError:
+		return "Throttling"
+	case AccessDeniedError:
+		return "AccessDenied"
+	case InvalidParameterError:
+		return "InvalidParameter"
+	case UnknownError:
+		return "Unknown"
+	case ConcurrentWriterError:
+		return "ConcurrentWriter"
+	}
+	return fmt.Sprintf("ErrorCategory(%d)", int(c))
+}
+
+// DropPolicy is how a failed batch is handled for the category of its error.
+type DropPolicy int
+
+const (
+	// Retry retries the batch, within RetryDuration with DropAfterRetryDuration and within MaxDeliveryLatency.
+	Retry DropPolicy = iota
+	// DeadLetter passes the events to DeadLetter right away and reports the error on Errors.
+	DeadLetter
+	// Drop drops the events right away, without calling their done callbacks.
+	Drop
+	// Fallback is the handling of the error without policy: the invalid events are isolated and dropped
+	// for an InvalidParameterError, the batch is retried otherwise.
+	Fallback
+)
+
+func (d DropPolicy) String() string {
+	switch d {
+	case Retry:
+		return "Retry"
+	case DeadLetter:
+		return "DeadLetter"
+	case Drop:
+		return "Drop"
+	case Fallback:
+		return "Fallback"
+	}
+	return fmt.Sprintf("DropPolicy(%d)", int(d))
+}
+
+// DefaultErrorPolicies are the policies of the categories missing from ErrorPolicies.
+var DefaultErrorPolicies = map[ErrorCategory]DropPolicy{
+	ThrottlingError:       Retry,
+	AccessDeniedError:     DeadLetter,
+	InvalidParameterError: Fallback,
+	UnknownError:          Retry,
+	ConcurrentWriterError: DeadLetter,
+}
+
+var errorCategoryCodes = map[string]ErrorCategory{
+	"ThrottlingException":            ThrottlingError,
+	"Throttling":                     ThrottlingError,
+	"TooManyRequestsException":       ThrottlingError,
+	"RequestLimitExceeded":           ThrottlingError,
+	"AccessDeniedException":          AccessDeniedError,
+	"AccessDenied":                   AccessDeniedError,
+	"UnrecognizedClientException":    AccessDeniedError,
+	"InvalidParameterException":      InvalidParameterError,
+	"InvalidParameterCombination":    InvalidParameterError,
+	"InvalidParameterValueException": InvalidParameterError,
+}
+
+func errorCategory(err awserr.Error) ErrorCategory {
+	if c, ok := errorCategoryCodes[err.Code()]; ok {
+		return c
+	}
+	return UnknownError
+}
+
+func (p *pusher) errorPolicy(c ErrorCategory) DropPolicy {
+	if d, ok := p.ErrorPolicies[c]; ok {
+		return d
+	}
+	return DefaultErrorPolicies[c]
+}
+
+// applyErrorPolicy handles a batch failed with an error of the category according to its policy,
+// and returns whether it should be retried.
+func (p *pusher) applyErrorPolicy(b *logBatch, c ErrorCategory, err awserr.Error) bool {
+	policy := p.errorPolicy(c)
+	if policy == Fallback && c == InvalidParameterError {
+		p.Log.Warnf("%v, isolating the invalid log events for %v/%v", err, p.Group, p.Stream)
+		var iso invalidIsolation
+		p.isolateInvalidEvents(b.events, b.dones, err, &iso)
+		p.Log.Debugf("Pusher published %v of %v log events to group: %v stream: %v after isolating invalid log events in %v.", iso.delivered, len(b.events), p.Group, p.Stream, time.Since(b.startTime))
+		p.lastSentTime = time.Now()
+		if iso.err == nil {
+			return false
+		}
+		// The events left unresolved by a transient error are retried as a batch of their own
+		b.events, b.dones, b.lastErr = iso.events, iso.dones, iso.err
+		b.size = 0
+		for _, e := range b.events {
+			b.size += len(*e.Message) + p.eventHeaderSize()
+		}
+		p.setState(Retrying)
+		return true
+	}
+
+	switch policy {
+	case DeadLetter:
+		p.Log.Errorf("%v error received when sending logs to %v, request dead-lettered: %v", c, p.destination(), err)
+		p.reportError(err)
+		p.deadLetter(b.events, err)
+	case Drop:
+		p.Log.Errorf("%v error received when sending logs to %v, request dropped: %v", c, p.destination(), err)
+		p.counters.addDropped(len(b.events))
+		p.releaseEvents(b.events)
+	default:
+		p.Log.Errorf("Aws error received when sending logs to %v: %v", p.destination(), err)
+		p.setState(Retrying)
+		return true
+	}
+	p.setState(CircuitOpen)
+	return false
+}
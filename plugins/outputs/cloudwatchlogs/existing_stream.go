@@ -0,0 +1,91 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+const (
+	// defaultStaleStreamAge is how long an existing stream may not have been written to before it is reported.
+	defaultStaleStreamAge = 7 * 24 * time.Hour
+	// futureEventTolerance is how far ahead of the current time the last event of a stream may be, for the
+	// clock differences, before it is reported.
+	futureEventTolerance = 5 * time.Minute
+)
+
+// StreamAnomaly is a sign that an existing log stream the pusher writes to may belong to another writer
+// with different expectations, found by ValidateExistingStream.
+type StreamAnomaly int
+
+const (
+	// StaleStream is a stream last written to longer than StaleStreamAge ago.
+	StaleStream StreamAnomaly = iota
+	// FutureLastEvent is a stream whose last event time is ahead of the current time.
+	FutureLastEvent
+)
+
+func (a StreamAnomaly) String() string {
+	switch a {
+	case StaleStream:
+		return "StaleStream"
+	case FutureLastEvent:
+		return "FutureLastEvent"
+	}
+	return fmt.Sprintf("StreamAnomaly(%d)", int(a))
+}
+
+// validateExistingStream describes the log stream CloudWatch Logs reported as already existing, and reports
+// its anomalies. A failure to describe it is only logged, the stream is written to either way.
+func (p *pusher) validateExistingStream() {
+	describer, ok := p.Service.(logStreamDescriber)
+	if !ok {
+		return
+	}
+	output, err := describer.DescribeLogStreams(&cloudwatchlogs.DescribeLogStreamsInput{
+		LogGroupName:        &p.Group,
+		LogStreamNamePrefix: &p.Stream,
+	})
+	if err != nil {
+		p.Log.Debugf("Unable to describe the existing stream %v/%v: %v", p.Group, p.Stream, err)
+		return
+	}
+	if s := findLogStream(output, p.Stream); s != nil {
+		p.checkExistingStream(s, time.Now())
+	}
+}
+
+// checkExistingStream warns about the anomalies of the existing stream s at now, and passes them to OnStreamAnomaly.
+func (p *pusher) checkExistingStream(s *cloudwatchlogs.LogStream, now time.Time) {
+	staleAge := p.StaleStreamAge
+	if staleAge <= 0 {
+		staleAge = defaultStaleStreamAge
+	}
+	// A stream never written to is new enough, whoever created it
+	last := s.LastIngestionTime
+	if last == nil {
+		last = s.LastEventTimestamp
+	}
+	if last != nil {
+		if t := time.Unix(0, *last*int64(time.Millisecond)); now.Sub(t) > staleAge {
+			p.Log.Warnf("Log stream %v/%v already exists and was last written to at %v, over %v ago. It may belong to another writer.", p.Group, p.Stream, t.Format(time.RFC3339), staleAge)
+			p.streamAnomaly(StaleStream)
+		}
+	}
+	if s.LastEventTimestamp != nil {
+		if t := time.Unix(0, *s.LastEventTimestamp*int64(time.Millisecond)); t.Sub(now) > futureEventTolerance {
+			p.Log.Warnf("Log stream %v/%v already exists and its last event is at %v, in the future. Another writer may send it events with a skewed clock or in another unit.", p.Group, p.Stream, t.Format(time.RFC3339))
+			p.streamAnomaly(FutureLastEvent)
+		}
+	}
+}
+
+func (p *pusher) streamAnomaly(a StreamAnomaly) {
+	if p.OnStreamAnomaly != nil {
+		p.OnStreamAnomaly(p.Group, p.Stream, a)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"sync"
+	"time"
+)
+
+// FlushCoordinator staggers the flushes of the pushers registered with it, so a burst across many
+// pushers does not turn into a burst of PutLogEvents requests. Each pusher flushes in its own slot of
+// its flush timeout period, the slots being spread evenly over the period, so a batch still never waits
+// longer than the flush timeout. The flush jitter is not applied to the coordinated pushers.
+type FlushCoordinator struct {
+	mu      sync.Mutex
+	epoch   time.Time
+	pushers []*pusher
+}
+
+func NewFlushCoordinator() *FlushCoordinator {
+	return &FlushCoordinator{epoch: time.Now()}
+}
+
+// Register adds the pusher to the coordinated pushers, the pusher leaves the coordinator once stopped.
+func (c *FlushCoordinator) Register(p *pusher) error {
+	c.mu.Lock()
+	c.pushers = append(c.pushers, p)
+	c.mu.Unlock()
+	err := p.controlWait(func() {
+		p.coordinator = c
+		p.resetFlushTimer()
+	})
+	if err != nil {
+		c.Unregister(p)
+	}
+	return err
+}
+
+// Unregister removes the pusher from the coordinated pushers, the slots of the others are spread again.
+func (c *FlushCoordinator) Unregister(p *pusher) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, r := range c.pushers {
+		if r == p {
+			c.pushers = append(c.pushers[:i], c.pushers[i+1:]...)
+			return
+		}
+	}
+}
+
+// nextFlush returns the time until the next flush slot of the pusher, at most period.
+func (c *FlushCoordinator) nextFlush(p *pusher, period time.Duration) time.Duration {
+	if period <= 0 {
+		return period
+	}
+	c.mu.Lock()
+	i, n := -1, len(c.pushers)
+	for j, r := range c.pushers {
+		if r == p {
+			i = j
+			break
+		}
+	}
+	c.mu.Unlock()
+	if i < 0 {
+		return period
+	}
+
+	offset := period * time.Duration(i) / time.Duration(n)
+	elapsed := (time.Since(c.epoch) - offset) % period
+	if elapsed < 0 {
+		elapsed += period
+	}
+	return period - elapsed
+}
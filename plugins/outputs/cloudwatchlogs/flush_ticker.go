@@ -0,0 +1,95 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultFlushTickInterval = 100 * time.Millisecond
+
+// FlushTicker replaces the flush timers of the pushers registered with it by a single ticker. On each tick,
+// every pusher checks whether its batch is due for a flush, so the batches are flushed up to an interval
+// later than with their own timer. With thousands of pushers, it saves the runtime as many active timers.
+// The pushers not registered keep their own flush timer.
+type FlushTicker struct {
+	interval time.Duration
+
+	mu      sync.Mutex
+	pushers map[*pusher]struct{}
+	ticker  *time.Ticker
+	stop    chan struct{}
+}
+
+// NewFlushTicker returns a ticker firing every interval, 100ms when it is 0. It starts with the first
+// pusher registered.
+func NewFlushTicker(interval time.Duration) *FlushTicker {
+	if interval <= 0 {
+		interval = defaultFlushTickInterval
+	}
+	return &FlushTicker{
+		interval: interval,
+		pushers:  make(map[*pusher]struct{}),
+		stop:     make(chan struct{}),
+	}
+}
+
+// Register moves the pusher from its flush timer to the ticker, the pusher leaves the ticker once stopped.
+func (t *FlushTicker) Register(p *pusher) error {
+	t.mu.Lock()
+	t.pushers[p] = struct{}{}
+	if t.ticker == nil {
+		t.ticker = time.NewTicker(t.interval)
+		go t.run(t.ticker, t.stop)
+	}
+	t.mu.Unlock()
+	err := p.controlWait(func() {
+		p.ticker = t
+		p.resetFlushTimer()
+	})
+	if err != nil {
+		t.Unregister(p)
+	}
+	return err
+}
+
+// Unregister removes the pusher from the ticker.
+func (t *FlushTicker) Unregister(p *pusher) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.pushers, p)
+}
+
+// Stop stops the ticker until a pusher is registered again. Meanwhile, the registered pushers no longer
+// flush on timeout, only when their batch is full or they are stopped.
+func (t *FlushTicker) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.ticker != nil {
+		t.ticker.Stop()
+		close(t.stop)
+		t.ticker = nil
+		t.stop = make(chan struct{})
+	}
+}
+
+func (t *FlushTicker) run(ticker *time.Ticker, stop chan struct{}) {
+	for {
+		select {
+		case <-ticker.C:
+			t.mu.Lock()
+			for p := range t.pushers {
+				// A pusher still busy with the previous tick skips this one
+				select {
+				case p.tickCh <- struct{}{}:
+				default:
+				}
+			}
+			t.mu.Unlock()
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"time"
+)
+
+const defaultHeartbeatMessage = "amazon-cloudwatch-agent heartbeat"
+
+// heartbeatLogEvent is the event sent by the pusher to prove its liveness.
+type heartbeatLogEvent struct {
+	msg string
+	t   time.Time
+}
+
+func (e *heartbeatLogEvent) Message() string { return e.msg }
+func (e *heartbeatLogEvent) Time() time.Time { return e.t }
+func (e *heartbeatLogEvent) Done()           {}
+
+// armHeartbeat starts the heartbeat timer once HeartbeatInterval is set, it is rearmed on each expiry.
+func (p *pusher) armHeartbeat() {
+	if p.HeartbeatInterval <= 0 || p.heartbeatArmed {
+		return
+	}
+	p.heartbeatArmed = true
+	p.heartbeatTimer.Reset(p.untilHeartbeat())
+}
+
+// untilHeartbeat returns the time left until a heartbeat is due, HeartbeatInterval after the last send
+// or the start of the pusher.
+func (p *pusher) untilHeartbeat() time.Duration {
+	last := p.lastSentTime
+	if last.Before(p.startTime) {
+		last = p.startTime
+	}
+	return p.HeartbeatInterval - time.Since(last)
+}
+
+// heartbeat sends a heartbeat event if nothing was sent for HeartbeatInterval. The events buffered
+// are sent by the flush timer instead.
+func (p *pusher) heartbeat() {
+	wait := p.untilHeartbeat()
+	if wait <= 0 {
+		if len(p.events) == 0 && !p.paused {
+			msg := p.HeartbeatMessage
+			if msg == "" {
+				msg = defaultHeartbeatMessage
+			}
+			p.addToBatch(&heartbeatLogEvent{msg: msg, t: time.Now()})
+			p.send()
+		}
+		wait = p.HeartbeatInterval
+	}
+	p.heartbeatTimer.Reset(wait)
+}
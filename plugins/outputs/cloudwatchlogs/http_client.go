@@ -0,0 +1,33 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// NewHTTPClient returns an HTTP client tuned for many pushers sending to the same CloudWatch Logs endpoint,
+// to set as the HTTPClient of the plugin. The default transport only keeps 2 idle connections per host,
+// so with more pushers sending at once, most requests open a new TLS connection. For high throughput
+// fleets, maxIdleConnsPerHost should be about the number of pushers sending concurrently, such as 100,
+// and timeout above the time PutLogEvents takes for a full batch, such as 30 seconds.
+func NewHTTPClient(maxIdleConnsPerHost int, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   10 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConns:          maxIdleConnsPerHost,
+			MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
+	}
+}
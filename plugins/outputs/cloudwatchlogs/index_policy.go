@@ -0,0 +1,79 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+const opPutIndexPolicy = "PutIndexPolicy"
+
+// PutIndexPolicyInput is the input of the PutIndexPolicy API, which the vendored SDK predates.
+type PutIndexPolicyInput struct {
+	_ struct{} `type:"structure"`
+
+	LogGroupIdentifier *string `locationName:"logGroupIdentifier" min:"1" type:"string" required:"true"`
+	PolicyDocument     *string `locationName:"policyDocument" min:"1" type:"string" required:"true"`
+}
+
+// PutIndexPolicyOutput is the output of the PutIndexPolicy API.
+type PutIndexPolicyOutput struct {
+	_ struct{} `type:"structure"`
+}
+
+// logsClient adds the CloudWatch Logs APIs missing from the SDK client.
+type logsClient struct {
+	*cloudwatchlogs.CloudWatchLogs
+}
+
+var _ indexPolicyPutter = (*logsClient)(nil)
+
+func (c *logsClient) PutIndexPolicy(input *PutIndexPolicyInput) (*PutIndexPolicyOutput, error) {
+	op := &request.Operation{
+		Name:       opPutIndexPolicy,
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+	output := &PutIndexPolicyOutput{}
+	req := c.NewRequest(op, input, output)
+	return output, req.Send()
+}
+
+// putFieldIndexes configures the field index policy of the log group with the FieldIndexes, once per pusher
+// whether the group was created or found existing. A failure is only logged, the log events can still be sent
+// without the indexes.
+func (p *pusher) putFieldIndexes() {
+	if len(p.FieldIndexes) == 0 {
+		return
+	}
+	p.fieldIndexesOnce.Do(p.doPutFieldIndexes)
+}
+
+func (p *pusher) doPutFieldIndexes() {
+	putter, ok := p.Service.(indexPolicyPutter)
+	if !ok {
+		p.Log.Debugf("The service cannot put the field index policy of log group %v, skipping it", p.Group)
+		return
+	}
+	doc, err := json.Marshal(struct {
+		Fields []string `json:"Fields"`
+	}{p.FieldIndexes})
+	if err != nil {
+		p.Log.Errorf("Unable to build the field index policy of log group %v: %v", p.Group, err)
+		return
+	}
+	policy := string(doc)
+	_, err = putter.PutIndexPolicy(&PutIndexPolicyInput{
+		LogGroupIdentifier: &p.Group,
+		PolicyDocument:     &policy,
+	})
+	if err != nil {
+		p.Log.Warnf("Unable to put the field index policy %v on log group %v: %v", policy, p.Group, err)
+		return
+	}
+	p.Log.Debugf("Put the field index policy %v on log group %v", policy, p.Group)
+}
@@ -0,0 +1,120 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var errCreateThrottled = errors.New("log group and stream creation rate limited")
+
+// LogGroupManager is shared by the pushers of an account and region to manage the creation of their log
+// groups and streams. It limits the CreateLogGroup and CreateLogStream calls of all of them to CreatesPerMinute,
+// separately from PutLogEvents, so the creations of a mass restart are smoothed instead of hitting the API limits.
+// A call over the limit is not made: the batch needing it is retried with backoff like a throttled send.
+type LogGroupManager struct {
+	// MaxConcurrentCreates bounds the creations of a log stream, with its log group when missing, in progress
+	// at once. The pushers over it wait for their turn in order, their send resuming once their creation
+	// is done, or give up waiting when they are stopped. There is no bound when it is 0. It must be set before
+	// the manager is shared.
+	MaxConcurrentCreates int
+
+	createsPerMinute int
+
+	mu      sync.Mutex
+	tokens  float64
+	last    time.Time
+	active  int             // creations in progress
+	waiters []chan struct{} // creations waiting for MaxConcurrentCreates, in order
+}
+
+// NewLogGroupManager returns a manager allowing createsPerMinute create calls per minute, in bursts of
+// up to a second worth of them. There is no limit when createsPerMinute is 0.
+func NewLogGroupManager(createsPerMinute int) *LogGroupManager {
+	m := &LogGroupManager{createsPerMinute: createsPerMinute, last: time.Now()}
+	m.tokens = m.burst()
+	return m
+}
+
+func (m *LogGroupManager) burst() float64 {
+	b := float64(m.createsPerMinute) / 60
+	if b < 1 {
+		b = 1
+	}
+	return b
+}
+
+// allowCreate takes a create call from the limit, returning false when there is none left.
+func (m *LogGroupManager) allowCreate() bool {
+	if m == nil || m.createsPerMinute <= 0 {
+		return true
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.tokens += now.Sub(m.last).Minutes() * float64(m.createsPerMinute)
+	if b := m.burst(); m.tokens > b {
+		m.tokens = b
+	}
+	m.last = now
+	if m.tokens < 1 {
+		return false
+	}
+	m.tokens--
+	return true
+}
+
+// acquireCreate waits until a creation can start within MaxConcurrentCreates, after the ones already waiting.
+// It returns false without starting one when stop is closed first.
+func (m *LogGroupManager) acquireCreate(stop <-chan struct{}) bool {
+	if m == nil || m.MaxConcurrentCreates <= 0 {
+		return true
+	}
+	m.mu.Lock()
+	if m.active < m.MaxConcurrentCreates && len(m.waiters) == 0 {
+		m.active++
+		m.mu.Unlock()
+		return true
+	}
+	ch := make(chan struct{})
+	m.waiters = append(m.waiters, ch)
+	m.mu.Unlock()
+
+	select {
+	case <-ch:
+		return true
+	case <-stop:
+	}
+	m.mu.Lock()
+	for i, w := range m.waiters {
+		if w == ch {
+			m.waiters = append(m.waiters[:i], m.waiters[i+1:]...)
+			m.mu.Unlock()
+			return false
+		}
+	}
+	m.mu.Unlock()
+	// The turn was handed over as the pusher stopped, it goes to the next one
+	m.releaseCreate()
+	return false
+}
+
+// releaseCreate ends a creation started by acquireCreate, handing its turn to the first one waiting.
+func (m *LogGroupManager) releaseCreate() {
+	if m == nil || m.MaxConcurrentCreates <= 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.waiters) == 0 {
+		m.active--
+		return
+	}
+	close(m.waiters[0])
+	m.waiters[0] = nil
+	m.waiters = m.waiters[1:]
+}
@@ -0,0 +1,62 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// defaultMetricValue is the value published when a log event matches a metric filter without MetricValue.
+const defaultMetricValue = "1"
+
+// MetricFilterSpec is a metric filter put on the log group once it is created, publishing a CloudWatch
+// metric from the log events matching Pattern, such as a count of the ERROR lines.
+type MetricFilterSpec struct {
+	Name            string
+	Pattern         string
+	MetricName      string
+	MetricNamespace string
+	// MetricValue is published for each matching event, a number or a $.field of JSON events, 1 when empty.
+	MetricValue string
+	// DefaultValue is published for the periods without matching events, when not nil.
+	DefaultValue *float64
+}
+
+var _ metricFilterPutter = (*logsClient)(nil)
+
+// putMetricFilters puts the MetricFilters on the log group. A failure is only logged, the log events
+// can still be sent without the filters.
+func (p *pusher) putMetricFilters() {
+	if len(p.MetricFilters) == 0 {
+		return
+	}
+	putter, ok := p.Service.(metricFilterPutter)
+	if !ok {
+		p.Log.Debugf("The service cannot put the metric filters of log group %v, skipping them", p.Group)
+		return
+	}
+	for _, f := range p.MetricFilters {
+		f := f
+		value := f.MetricValue
+		if value == "" {
+			value = defaultMetricValue
+		}
+		_, err := putter.PutMetricFilter(&cloudwatchlogs.PutMetricFilterInput{
+			LogGroupName:  &p.Group,
+			FilterName:    &f.Name,
+			FilterPattern: &f.Pattern,
+			MetricTransformations: []*cloudwatchlogs.MetricTransformation{{
+				MetricName:      &f.MetricName,
+				MetricNamespace: &f.MetricNamespace,
+				MetricValue:     &value,
+				DefaultValue:    f.DefaultValue,
+			}},
+		})
+		if err != nil {
+			p.Log.Warnf("Unable to put the metric filter %v on log group %v: %v", f.Name, p.Group, err)
+			continue
+		}
+		p.Log.Debugf("Put the metric filter %v on log group %v", f.Name, p.Group)
+	}
+}
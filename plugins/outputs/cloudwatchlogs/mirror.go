@@ -0,0 +1,61 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"io"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+const mirrorChSize = 100
+
+// mirror queues the messages of delivered events for the MirrorWriter, dropping them when the queue is full.
+func (p *pusher) mirror(events []*cloudwatchlogs.InputLogEvent) {
+	if p.MirrorWriter == nil {
+		return
+	}
+	p.mirrorOnce.Do(func() {
+		p.mirrorCh = make(chan []string, mirrorChSize)
+		go p.writeMirror()
+	})
+
+	msgs := make([]string, len(events))
+	for i, e := range events {
+		msgs[i] = *e.Message
+	}
+	select {
+	case p.mirrorCh <- msgs:
+	default:
+		p.Log.Warnf("Mirror of %v/%v is falling behind, %v delivered log events not mirrored", p.Group, p.Stream, len(msgs))
+	}
+}
+
+// writeMirror writes the queued messages until the pusher is done, then the remaining ones.
+func (p *pusher) writeMirror() {
+	for {
+		select {
+		case msgs := <-p.mirrorCh:
+			p.writeMirrorMessages(msgs)
+		case <-p.done:
+			for {
+				select {
+				case msgs := <-p.mirrorCh:
+					p.writeMirrorMessages(msgs)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (p *pusher) writeMirrorMessages(msgs []string) {
+	for _, msg := range msgs {
+		if _, err := io.WriteString(p.MirrorWriter, msg+"\n"); err != nil {
+			p.Log.Warnf("Unable to mirror the delivered log events of %v: %v", p.targetName(), err)
+			return
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"github.com/aws/amazon-cloudwatch-agent/logs"
+)
+
+// partitionKey returns the PartitionFn key of the event, the key of the oversized event for its chunks,
+// so they are sent together.
+func (p *pusher) partitionKey(e logs.LogEvent) string {
+	if se, ok := e.(*splitLogEvent); ok {
+		e = se.source
+	}
+	return p.PartitionFn(e)
+}
+
+// partitionBatches splits the batch by the PartitionFn keys of its events, in the order of the first event
+// of each key. The events of a key keep their order. The batches are copies, not sharing the buffers.
+func (p *pusher) partitionBatches(b *logBatch) []*logBatch {
+	var batches []*logBatch
+	byKey := make(map[string]*logBatch)
+	for i, e := range b.events {
+		key := p.partitions[p.seqs[i]]
+		pb, ok := byKey[key]
+		if !ok {
+			pb = &logBatch{startTime: b.startTime}
+			byKey[key] = pb
+			batches = append(batches, pb)
+		}
+		pb.events = append(pb.events, e)
+		pb.dones = append(pb.dones, b.dones[i])
+		pb.size += len(*e.Message) + p.eventHeaderSize()
+	}
+	for _, pb := range batches {
+		pb.deadline = p.earliestDeadline(pb.events)
+	}
+	return batches
+}
+
+// sendPartitions sends the batch in a request per partition when its events have several PartitionFn keys,
+// and reports whether it did.
+func (p *pusher) sendPartitions(b *logBatch) bool {
+	batches := p.partitionBatches(b)
+	if len(batches) < 2 {
+		return false
+	}
+	p.reset()
+	for _, pb := range batches {
+		if p.paused {
+			p.holdBatch(pb)
+		} else {
+			p.dispatch(pb)
+		}
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// defaultPauseBufferSize is the default number of bytes of log events held while paused.
+const defaultPauseBufferSize = 10 * reqSizeLimit
+
+var errPauseBufferFull = errors.New("pause buffer full")
+
+// Pause stops sending to CloudWatch Logs until Resume is called, without losing the pusher state.
+// Events are still accepted and held in batches, up to PauseBufferSize bytes, beyond which the
+// oldest batches are dead-lettered.
+func (p *pusher) Pause() error {
+	return p.controlWait(func() {
+		if !p.paused {
+			p.Log.Infof("Pausing pusher for %v/%v", p.Group, p.Stream)
+			p.paused = true
+		}
+	})
+}
+
+// Resume sends the batches held while paused, then the current batch, and resumes sending.
+func (p *pusher) Resume() error {
+	return p.controlWait(func() {
+		if !p.paused {
+			return
+		}
+		p.Log.Infof("Resuming pusher for %v/%v, sending %v held batches", p.Group, p.Stream, len(p.pausedBatches))
+		p.paused = false
+		p.flushPaused()
+		if len(p.events) > 0 {
+			p.send()
+		}
+	})
+}
+
+// controlWait runs f inside the start loop like control, and waits for it to complete.
+func (p *pusher) controlWait(f func()) error {
+	done := make(chan struct{})
+	err := p.control(func() {
+		f()
+		close(done)
+	})
+	if err != nil {
+		return err
+	}
+	select {
+	case <-done:
+		return nil
+	case <-p.stop:
+		return errPusherStopped
+	}
+}
+
+// holdBatch keeps the batch while paused, dropping the oldest held batches over PauseBufferSize.
+func (p *pusher) holdBatch(b *logBatch) {
+	// The batch outlives the buffers, which are reused after reset
+	b.events = append([]*cloudwatchlogs.InputLogEvent(nil), b.events...)
+	b.dones = append(make([]func(), 0, len(b.dones)), b.dones...)
+	p.pausedBatches = append(p.pausedBatches, b)
+	p.pausedSize += b.size
+
+	limit := p.PauseBufferSize
+	if limit <= 0 {
+		limit = defaultPauseBufferSize
+	}
+	for p.pausedSize > limit && len(p.pausedBatches) > 1 {
+		oldest := p.pausedBatches[0]
+		p.Log.Errorf("Pause buffer of %v/%v is full, dropping the oldest held batch of %v log events.", p.Group, p.Stream, len(oldest.events))
+		p.deadLetter(oldest.events, errPauseBufferFull)
+		p.pausedSize -= oldest.size
+		p.pausedBatches[0] = nil
+		p.pausedBatches = p.pausedBatches[1:]
+	}
+}
+
+// flushPaused sends the batches held while paused, in order.
+func (p *pusher) flushPaused() {
+	batches := p.pausedBatches
+	p.pausedBatches = nil
+	p.pausedSize = 0
+	for _, b := range batches {
+		// RetryDuration starts from the actual send
+		b.startTime = time.Now()
+		p.dispatch(b)
+	}
+}
@@ -4,14 +4,25 @@
 package cloudwatchlogs
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
 	"math/rand"
+	"net"
+	"reflect"
+	"regexp"
 	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/amazon-cloudwatch-agent/logs"
 	"github.com/aws/amazon-cloudwatch-agent/profiler"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
 	"github.com/influxdata/telegraf"
 )
@@ -19,10 +30,45 @@ import (
 const (
 	reqSizeLimit   = 1024 * 1024
 	reqEventsLimit = 10000
+
+	errorsChSize = 100
+
+	noBatchWarnInterval = time.Minute
+
+	truncateWarnInterval   = time.Minute
+	maxTruncateMarkerTries = 3
+
+	defaultFlushJitter = 0.1
+
+	partialEventTimeout = 5 * time.Second
+
+	// Weight of the latest arrival interval in its moving average, and the arrival
+	// rate (events/s) at which the adaptive flush timeout is halfway between min and max.
+	arrivalIntervalWeight = 0.1
+	adaptiveFlushHalfRate = 100.0
+
+	isolateTokenRetries = 3
+
+	defaultSequenceTokenRetries = 5
+
+	// Batches up to insertionSortMaxEvents events, or with up to insertionSortMaxOutOfOrder events
+	// out of order and insertionSortMaxSwapsPerEvent swaps per event on average, are sorted by insertion.
+	insertionSortMaxEvents        = 32
+	insertionSortMaxOutOfOrder    = 8
+	insertionSortMaxSwapsPerEvent = 16
+
+	createStreamRetries   = 3
+	createStreamRetryWait = 200 * time.Millisecond
 )
 
 var (
 	seededRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	jitterMu   sync.Mutex
+
+	logGroupNameRegex = regexp.MustCompile(`^[\.\-_/#A-Za-z0-9]{1,512}$`)
+
+	errPusherStopped           = errors.New("pusher stopped")
+	errDeliveryLatencyExceeded = errors.New("maximum delivery latency exceeded")
 )
 
 type CloudWatchLogsService interface {
@@ -31,65 +77,564 @@ type CloudWatchLogsService interface {
 	CreateLogGroup(input *cloudwatchlogs.CreateLogGroupInput) (*cloudwatchlogs.CreateLogGroupOutput, error)
 }
 
+// logStreamDescriber is implemented by the services able to describe the log streams, such as the SDK client.
+// It is optional: without it, the log streams are not looked up before being created, nor validated.
+type logStreamDescriber interface {
+	DescribeLogStreams(input *cloudwatchlogs.DescribeLogStreamsInput) (*cloudwatchlogs.DescribeLogStreamsOutput, error)
+}
+
+// logGroupDescriber is implemented by the services able to describe the log groups, such as the SDK client.
+// It is optional: without it, the retention of the log group is not fetched, nor the log group validated.
+type logGroupDescriber interface {
+	DescribeLogGroups(input *cloudwatchlogs.DescribeLogGroupsInput) (*cloudwatchlogs.DescribeLogGroupsOutput, error)
+}
+
+// indexPolicyPutter is implemented by the services able to put the index policy of a log group, such as the
+// client of the plugin. It is optional: without it, the FieldIndexes are not configured.
+type indexPolicyPutter interface {
+	PutIndexPolicy(input *PutIndexPolicyInput) (*PutIndexPolicyOutput, error)
+}
+
+// metricFilterPutter is implemented by the services able to put the metric filters of a log group, such as
+// the SDK client. It is optional: without it, the MetricFilters are not put.
+type metricFilterPutter interface {
+	PutMetricFilter(input *cloudwatchlogs.PutMetricFilterInput) (*cloudwatchlogs.PutMetricFilterOutput, error)
+}
+
+// pusherOptions are the options of a pusher which its shards and the pushers of its routed streams
+// inherit, copied by copyOptions when they are created.
+type pusherOptions struct {
+	// TruncateMarker builds the final message when a message exceeds the size limit. It receives the
+	// original message and its truncated prefix, which is shortened when the result exceeds the size limit.
+	// The result is cut to the size limit if it is still too long. Defaults to appending truncatedSuffix.
+	TruncateMarker func(original, truncated string) string
+	// DeadLetter receives the events dropped because they could not be delivered, together with the cause.
+	// Events are simply dropped when it is nil.
+	DeadLetter func(group, stream string, events []*cloudwatchlogs.InputLogEvent, err error)
+	// NoBatch sends every event in its own request as soon as it is received, bypassing the flush timer.
+	// It is meant for low volume streams needing minimal latency, as it makes one API call per event.
+	NoBatch bool
+	// StartupSyncEvents sends the first StartupSyncEvents events in their own request as soon as they are
+	// received, like NoBatch, for a quick first feedback after startup. The pusher switches to batching
+	// once they are sent, or as soon as events queue up. The events sent this way are counted in Stats.
+	StartupSyncEvents int
+	// HeartbeatInterval sends HeartbeatMessage, "amazon-cloudwatch-agent heartbeat" when empty, once nothing
+	// was sent for HeartbeatInterval, so a quiet stream still proves the agent is alive. The heartbeats
+	// have their own timer, started on the first flush timeout or tick. There is no heartbeat when it is 0.
+	HeartbeatInterval time.Duration
+	HeartbeatMessage  string
+	// SortEvents sorts the events of each batch by timestamp when some were added out of order. It is set
+	// by NewPusher. Unset, the events are sent in the order they were added, without checking it: the source
+	// must guarantee the chronological order, as CloudWatch Logs rejects the requests whose events are not
+	// in chronological order with InvalidParameterException, and the events isolated as invalid are dropped.
+	SortEvents bool
+	// DropFlushMarkers only flushes the batch on the events marked with FlushMarkerLogEvent, without sending them.
+	DropFlushMarkers bool
+	// PostConvert is called with each converted event and its source event before it is buffered, giving
+	// a last chance to modify it. It runs on the hot path of every event and must be fast. The size limits
+	// are checked after it runs, but a message it makes too long is not truncated again.
+	PostConvert func(*cloudwatchlogs.InputLogEvent, logs.LogEvent)
+	// OnSequenceToken is called from the send loop whenever a successful send changes the sequence token,
+	// so the token can be persisted right after the send.
+	OnSequenceToken func(group, stream string, token *string)
+	// MaxBatchEvents is the maximum number of events sent in one request, bounded by the 10000 events
+	// allowed by PutLogEvents. The hard limit is used when it is 0.
+	MaxBatchEvents int
+	// MinBatchEvents holds a batch with fewer events once when the flush timeout expires, for another
+	// flush timeout, so light loads are sent in fewer requests at the cost of latency. The batch is held
+	// until MaxBatchAge after its first event at most, when it is not 0. All batches are sent on timeout
+	// when it is 0 or 1.
+	MinBatchEvents int
+	MaxBatchAge    time.Duration
+	// MaxBatchWait bounds the time from the first event of a batch being added to the batch being sent,
+	// even when the flush timer keeps being postponed or the batch is held for MinBatchEvents. It is checked
+	// as each event is added and by a timer of its own. There is no bound when it is 0.
+	MaxBatchWait time.Duration
+	// EventHeaderSize is the overhead in bytes accounted per event against the request size limit,
+	// the documented 26 bytes when it is 0 or too large for a message of MsgSizeLimit to fit a request.
+	EventHeaderSize int
+	// MsgSizeLimit is the maximum message size in bytes, longer messages are truncated. The documented
+	// 256KB minus the event header is used when it is 0 or does not fit the truncation suffix or a request
+	// with the documented event header.
+	MsgSizeLimit int
+	// SplitOversized sends the messages longer than the size limit as several consecutive events
+	// marked [1/n] to [n/n] instead of truncating them.
+	SplitOversized bool
+	// FieldIndexes are the JSON fields indexed by the field index policy put on the log group once, when
+	// the pusher creates it or first sends to it already existing. No policy is put when it is empty, or when
+	// the service cannot put index policies.
+	FieldIndexes []string
+	// MetricFilters are put on the log group once it is created, so the log events also publish the metrics
+	// of common operational signals. A filter failing to be put is logged and skipped. None are put when empty,
+	// or when the service cannot put metric filters.
+	MetricFilters []MetricFilterSpec
+	// ValidateExistingStream checks the log stream when it is found already existing, whether described before
+	// being created or reported existing as it is created, and warns about the signs of a stream name colliding with another writer's: a stream last written
+	// to longer than StaleStreamAge ago, 7 days when 0, or whose last event is in the future. OnStreamAnomaly
+	// is called with each anomaly found as well, to alert on them. The pusher writes to the stream either way.
+	ValidateExistingStream bool
+	StaleStreamAge         time.Duration
+	OnStreamAnomaly        func(group, stream string, anomaly StreamAnomaly)
+	// DetectClockSkew estimates the clock skew from the event timestamps ahead of the current time,
+	// and warns when they are consistently ahead. ClampClockSkew also shifts the timestamps of the
+	// following events back by the estimated skew.
+	DetectClockSkew bool
+	ClampClockSkew  bool
+	// DetectTimestampUnits flags the events whose time is 1000 times too far from the median of the recent
+	// event times of the stream, in January 1970 or around the year 52000, as from a source mixing timestamps
+	// in seconds and milliseconds. They are counted in Stats and warned about, then dropped as out of the
+	// accepted time range. CorrectTimestampUnits scales their time by 1000 instead, so they are sent.
+	DetectTimestampUnits  bool
+	CorrectTimestampUnits bool
+	// OnReject is called with the events of a successful send which CloudWatch Logs rejected, per reason.
+	OnReject func(reason RejectReason, events []*cloudwatchlogs.InputLogEvent)
+	// RetryQueueSize enables a queue of up to RetryQueueSize failed batches, retried from the event loop
+	// so new events keep being accepted meanwhile. When the queue is full, the oldest batch is dead-lettered.
+	// Failed batches are retried inline, blocking the event loop, when it is 0.
+	RetryQueueSize int
+	// DropAfterRetryDuration dead-letters a batch once retrying it would exceed RetryDuration from its first
+	// attempt. Unset, a batch is retried until it is delivered, with an error logged once RetryDuration is exceeded.
+	DropAfterRetryDuration bool
+	// MaxDeliveryLatency bounds the time spent delivering a batch across all its retries, so a stuck
+	// batch does not hold back the fresher events. A batch not delivered in time is dropped and counted
+	// in Stats as abandoned. RetryDuration is the only bound when it is 0.
+	MaxDeliveryLatency time.Duration
+	// PauseBufferSize is the number of bytes of log events held while paused, 10MB when it is 0.
+	PauseBufferSize int
+	// MirrorWriter receives the messages of the log events once CloudWatch Logs accepted them, one per line,
+	// as a local copy of what was delivered. It is written apart from the send loop, and best effort:
+	// the messages are dropped when the writer falls behind, so it is no delivery guarantee.
+	MirrorWriter io.Writer
+	// AuditWriter receives a JSON line per PutLogEvents attempt, with its outcome, number of events and bytes,
+	// and error code, as an audit trail of the pusher for a SIEM. Like MirrorWriter, it is written apart from
+	// the send loop and the records are dropped when the writer falls behind.
+	AuditWriter io.Writer
+	// SinkStrategy is how the batches feed CloudWatch Logs and ArchiveSink: CloudWatchOnly by default, Tee to
+	// archive every batch as well, or CloudWatchWithS3Overflow to archive only the events CloudWatch Logs does
+	// not take. With Tee, TeeDurability is whether the done callbacks wait for the archive. The archived events
+	// are counted in Stats. The events go to CloudWatch Logs only when ArchiveSink is nil.
+	SinkStrategy  SinkStrategy
+	ArchiveSink   ArchiveSink
+	TeeDurability TeeDurability
+	// BatchChecksum logs the SHA-256 checksum of each delivered batch with the sequence token returned,
+	// and OnBatchChecksum receives them, for an audit trail reconcilable with the source logs. The checksum
+	// is over each event in order, as its timestamp in milliseconds, a space, its message and a newline.
+	// It is only computed when either is set.
+	BatchChecksum   bool
+	OnBatchChecksum func(group, stream, checksum string, token *string)
+	// OnDelivered is called from the send loop with the ID and number of events of a batch added with
+	// AddEventsWithID, once all its events are delivered.
+	OnDelivered func(batchID string, count int)
+	// OnSendAttempt is called from the send loop after every PutLogEvents call, including the retries and
+	// the calls isolating invalid events, before its result is handled. It must be fast, as it delays the sends.
+	OnSendAttempt func(info SendAttemptInfo)
+	// ByteBudgetPerWindow caps the bytes delivered to the stream per ByteBudgetWindow, a minute when 0.
+	// Batches over the budget are held until the window rolls, or dropped when DropOverBudget is set.
+	// There is no budget when it is 0.
+	ByteBudgetPerWindow int
+	ByteBudgetWindow    time.Duration
+	DropOverBudget      bool
+	// AdaptiveFlush replaces FlushTimeout by a timeout between MinFlushTimeout and MaxFlushTimeout,
+	// shorter when few events arrive to reduce latency and longer when many arrive to fill the batches.
+	AdaptiveFlush   bool
+	MinFlushTimeout time.Duration
+	MaxFlushTimeout time.Duration
+	// FlushJitter randomizes each flush timeout by up to this fraction (0.1 is ±10%) so pushers created
+	// together do not flush together. The jittered timeout stays within MinFlushTimeout and MaxFlushTimeout when set.
+	FlushJitter float64
+	// ProjectFields keeps only the listed top-level keys of messages which are JSON objects.
+	// Other messages are sent unchanged.
+	ProjectFields []string
+	// Decoder transcodes the messages which are not valid UTF-8, such as the Latin-1 lines of legacy
+	// applications, before they are sent. See DecoderForEncoding. The messages are sent as is when nil.
+	Decoder func([]byte) string
+	// SanitizeControlChars replaces the null bytes and other control characters of the messages, except tabs
+	// and line breaks, with ControlCharReplacement, or removes them when it is 0. The sanitized messages
+	// are counted in Stats. Unsanitized, an event CloudWatch Logs rejects for them is isolated and dropped
+	// as invalid, the rest of its batch is still delivered.
+	SanitizeControlChars   bool
+	ControlCharReplacement rune
+	// RedactionRules mask the sensitive data of the messages, such as card numbers or API keys, before they
+	// leave the host; see DefaultRedactionRules for the built-in ones. Redactor is applied after them, for custom
+	// redactions. The redacted messages are counted in Stats. Redaction changes the messages stored in CloudWatch
+	// Logs, the original content is lost, and each rule scans every message on the hot path, so the rules should
+	// be few and simple. There is no redaction when both are unset.
+	RedactionRules []RedactionRule
+	Redactor       func(message string) string
+	// CollapseRepeatedFrames collapses the runs of consecutive identical lines of the multiline messages, such
+	// as the repeated frames of a deep recursion in a stack trace, into their first line followed by " (× N)",
+	// before the size limit is checked. FrameKey normalizes the lines compared, so the frames differing only
+	// by a line number or an address can be collapsed as well; the lines with an empty key are never
+	// collapsed. The lines are compared without their surrounding spaces when it is nil.
+	CollapseRepeatedFrames bool
+	FrameKey               func(line string) string
+	// TimestampRounding rounds the timestamp of each event down to a multiple of it, such as a second,
+	// so the events are bucketed evenly. It changes the timestamps stored in CloudWatch Logs, the original
+	// precision is lost. Rounding down keeps the events in order. There is no rounding when it is less
+	// than a millisecond.
+	TimestampRounding time.Duration
+	// WAL is the write-ahead log the events added with AddEvent are written to before being queued,
+	// and removed from once delivered, dropped or dead-lettered, so the events still queued are sent again
+	// by ReplayWAL after a crash, for the log group and stream recorded with them. The WAL is shared by the
+	// shards and the pushers of the routed streams, and not closed by the pusher.
+	WAL *WAL
+	// InjectSequenceNumber prefixes each message with "[seq:N] ", N counting the events of the group and
+	// stream from 1, so a query can find the events missing in CloudWatch Logs. It makes each message a
+	// few bytes longer, and JSON messages no longer parse as JSON.
+	InjectSequenceNumber bool
+	// TimestampSource is the time of the events used as their timestamp, which orders them and counts
+	// for the retention: EventTime by default, or IngestTime, such as for replayed logs whose event time
+	// is too old to be accepted. EmbedOtherTime adds the other time to each message, as an event_time or
+	// ingest_time field of JSON objects which do not have it already, or else as a key=value pair before
+	// the message.
+	TimestampSource TimestampSource
+	EmbedOtherTime  bool
+	// DroppedEventDonePolicy is whether the done callbacks of the events dropped on purpose, out of the
+	// accepted time range, sampled out, duplicated or flush markers, are called. With CallDone, the default,
+	// their source moves past them. With SkipDone, their source may read them again after a restart, but
+	// a source waiting for all its done callbacks never ends.
+	DroppedEventDonePolicy DroppedEventDonePolicy
+	// DoneCallbackDelay calls the done callbacks of the delivered events DoneCallbackDelay after the delivery
+	// instead of right away, so a source committing its offsets on them leaves time for an external
+	// verification. The callbacks still pending are called when the pusher stops.
+	DoneCallbackDelay time.Duration
+	// SuppressDoneOnShutdown skips the done callbacks once the pusher stops: the last batches are still
+	// delivered, but neither their callbacks nor the ones still delayed by DoneCallbackDelay are called, for
+	// a source whose offset store shuts down before the pusher. The source reads the events again after
+	// a restart, so they are delivered at least once.
+	SuppressDoneOnShutdown bool
+	// LogGroupManager limits the rate of the CreateLogGroup and CreateLogStream calls of the pushers
+	// sharing it, and the creations in progress at once. A send needing a creation over the rate limit is retried
+	// with backoff, one over the concurrency limit waits for its turn until the pusher stops. There is no limit
+	// when nil.
+	LogGroupManager *LogGroupManager
+	// EnrichFields adds the listed metadata fields, such as MetadataInstanceID or MetadataECSTaskARN, to each
+	// message: after the fields of JSON objects which do not have them already, or else as key=value pairs
+	// before the message. The metadata is fetched in the background from MetadataProvider when the first
+	// event is added, once for the pusher and its shards and streams, or from EC2ECSMetadata when nil, once
+	// per process. The messages are sent as is when it is unavailable.
+	EnrichFields     []string
+	MetadataProvider func() (map[string]string, error)
+	// EnvelopeFormat wraps the messages which are not JSON objects into a JSON envelope, with the event time,
+	// message and host name by default, so the subscribers of the log group parse them uniformly. The JSON
+	// objects are passed through, or get the envelope fields merged in with MergeJSON. It applies after the
+	// EnrichFields. The messages are sent as is when it is nil.
+	EnvelopeFormat *EnvelopeFormat
+	// DedupTTL drops the events whose message was already sent less than DedupTTL ago, so a message
+	// repeated among other ones is sent at most once per DedupTTL. The hashes of the last DedupCacheSize
+	// messages seen are kept, 10000 when it is 0. The dropped duplicates are counted in Stats.
+	// There is no deduplication when DedupTTL is 0.
+	DedupTTL       time.Duration
+	DedupCacheSize int
+	// RetentionBound drops the events older than the retention of the log group, fetched with
+	// DescribeLogGroups once the first event is added and every RetentionRefreshInterval, 1 hour when 0.
+	// CloudWatch Logs would reject them. The events up to 14 days old are sent while the retention is
+	// unknown, or when it is longer or unlimited.
+	RetentionBound           bool
+	RetentionRefreshInterval time.Duration
+	// PartitionFn sends the events of each flushed batch in a request per key it returns, in the order of the
+	// first event of each key, so a subscriber of the log group resharding the events gets a single key per
+	// request. Each request still holds the events of a single batch, within the size and count limits.
+	// It costs a request per key instead of one per batch, with smaller requests. The batches are sent
+	// whole when it is nil.
+	PartitionFn func(logs.LogEvent) string
+	// SamplingUnderPressure keeps 1 in SamplingRate of the added events, 10 when it is less than 2, while
+	// the events queue is overloaded: once it stayed at or above SamplingHighWater events for SamplingAfter,
+	// until it is back under half of SamplingHighWater. The high-water mark is 90% of the queue when 0
+	// or larger than the queue, and SamplingAfter is 10 seconds when 0. The events sampled out are
+	// counted in Stats.
+	SamplingUnderPressure bool
+	SamplingHighWater     int
+	SamplingAfter         time.Duration
+	SamplingRate          int
+	// ErrorPolicies maps the category of the AWS errors failing a send, other than the ones with a dedicated
+	// handling such as ResourceNotFoundException or InvalidSequenceTokenException, to how the batch is handled.
+	// The categories missing from it use DefaultErrorPolicies.
+	ErrorPolicies map[ErrorCategory]DropPolicy
+	// MaxSequenceTokenRetries is the number of times a batch is retried with the sequence token
+	// expected by CloudWatch Logs, 5 when 0. Past it, another writer is assumed to keep invalidating the token:
+	// the batch is handled by the ConcurrentWriterError policy, dead-lettered by default, and counted in Stats.
+	MaxSequenceTokenRetries int
+	// Entity is sent with the log events to associate them with the service or resource which produced them.
+	// It is omitted when nil, and logged and omitted when invalid or when the service is not an SDK client.
+	Entity *Entity
+}
+
 type pusher struct {
+	// Target is switched by SetTarget, from the loop under targetMu
 	Target
+	targetMu      sync.RWMutex
 	Service       CloudWatchLogsService
 	FlushTimeout  time.Duration
 	RetryDuration time.Duration
 	Log           telegraf.Logger
 
-	events              []*cloudwatchlogs.InputLogEvent
-	minT, maxT          *time.Time
-	doneCallbacks       []func()
-	eventsCh            chan logs.LogEvent
-	nonBlockingEventsCh chan logs.LogEvent
-	bufferredSize       int
-	flushTimer          *time.Timer
-	sequenceToken       *string
-	lastValidTime       int64
-	needSort            bool
-	stop                chan struct{}
-	lastSentTime        time.Time
+	pusherOptions
+	// RouteFn routes each event to the log group and stream it returns, the empty ones being the group
+	// and stream of the pusher. Without RouteFn, the events implementing StreamLogEvent are sent to their
+	// stream in the group of the pusher.
+	RouteFn func(logs.LogEvent) (group, stream string)
+	// DroppedMetricService publishes the number of log events dropped since the last publish as the
+	// DroppedLogEvents metric, dimensioned by log group and stream, every DroppedMetricInterval, a minute
+	// when 0, from the first event added. The shards and streams of the pusher are counted in its metric.
+	// Nothing is published when nil.
+	DroppedMetricService  CloudWatchMetricsService
+	DroppedMetricInterval time.Duration
+	// MaxStreams bounds the number of log groups and streams, other than the ones of the pusher, the events
+	// are batched for, 100 when 0. Each of them has its own batch, sequence token and flush timer, with the
+	// options of the pusher as set when its first event is added. The least recently used one is evicted to
+	// make room for a new one, and the ones without events for StreamIdleTimeout, 5 minutes when 0, are
+	// evicted as events are added. The batch of an evicted stream is still sent.
+	MaxStreams        int
+	StreamIdleTimeout time.Duration
+	// StreamFlushTimeout returns the flush timeout of the batch of each log group and stream routed or tagged
+	// by the events, when it is created, such as a short one for audit streams and a long one for debug
+	// streams. FlushTimeout is used when it is nil or returns 0.
+	StreamFlushTimeout func(Target) time.Duration
+	// ShardKeyFn and ShardCount spread the events over ShardCount streams named after the stream of the
+	// pusher suffixed with -0 to -<ShardCount-1>, by a hash of the key of each event, so the events of a
+	// key stay together and in order in one stream. Each shard has its own batch, sequence token and
+	// flush timer, with the options of the pusher as set when the first event is added.
+	// There is no sharding when ShardKeyFn is nil or ShardCount is less than 2.
+	ShardKeyFn func(logs.LogEvent) string
+	ShardCount int
+
+	events               []*cloudwatchlogs.InputLogEvent
+	minT, maxT           time.Time         // zero when the batch is empty
+	lastTimestamp        int64             // timestamp of the last buffered event, for the out of order check
+	seqs                 []uint64          // insertion sequence of each buffered event, to keep the arrival order of equal timestamps
+	partitions           map[uint64]string // PartitionFn key of each buffered event, by insertion sequence
+	splitTail            bool              // whether the batch starts with the last chunks of a split event
+	splitTailTimestamp   int64
+	batchDeadline        time.Time                                   // earliest deadline of the buffered events
+	eventDeadlines       map[*cloudwatchlogs.InputLogEvent]time.Time // deadlines of the batched events carrying one
+	nextSeq              uint64
+	outOfOrder           int             // number of buffered events older than the event before them
+	skewSamples          []time.Duration // offsets from now of the event times of the batch
+	skewedBatches        int
+	clockSkew            time.Duration
+	tsUnits              timestampUnits                             // recent event times, for DetectTimestampUnits
+	walEntries           map[*cloudwatchlogs.InputLogEvent][]uint64 // WAL entries of the batched events not delivered or dropped yet
+	doneCallbacks        []func()
+	eventsCh             chan logs.LogEvent
+	nonBlockingEventsCh  chan logs.LogEvent
+	bufferredSize        int
+	flushTimer           *time.Timer
+	sequenceToken        *string
+	lastValidTime        int64
+	needSort             bool
+	stop                 chan struct{}
+	stopOnce             sync.Once
+	done                 chan struct{} // closed once the start loop returned
+	lastSentTime         time.Time
+	lastFlushTime        time.Time    // when the last batch was sent, delivered or not
+	lastSuccessTime      atomic.Value // time.Time of the last successful PutLogEvents
+	streamCreatedTime    atomic.Value // time.Time of the last successful CreateLogStream
+	counters             *pusherCounters
+	state                int32 // PusherState, updated atomically by the send loop
+	retryQueue           []*logBatch
+	delayedDones         []delayedDones
+	suppressDones        bool // whether the done callbacks are skipped, during the shutdown flush
+	doneTimer            *time.Timer
+	retryTimer           *time.Timer
+	budgetHeld           []*logBatch // batches held over the byte budget until the window rolls
+	budgetTimer          *time.Timer
+	paused               bool
+	pausedBatches        []*logBatch // full batches held while paused
+	pausedSize           int
+	lastNoBatchWarnTime  time.Time
+	lastTruncateWarnTime time.Time
+	curFlushTimeout      time.Duration // flush timeout with jitter applied, set when the flush timer is reset
+	batchStart           time.Time     // when the first event of the batch was added
+	batchHeld            bool          // whether the batch was held for MinBatchEvents
+	batchWaitTimer       *time.Timer
+	startupSyncSent      int
+	startupSynced        bool // whether the StartupSyncEvents are over
+	startTime            time.Time
+	entityChecked        bool // whether the Entity was validated
+	entityInvalid        bool
+	heartbeatTimer       *time.Timer
+	heartbeatArmed       bool
+	coordinator          *FlushCoordinator
+	ticker               *FlushTicker
+	tickCh               chan struct{}
+	flushDeadline        time.Time // when the flush timeout expires, checked on the ticks of the ticker
+	lastArrivalTime      time.Time
+	avgArrivalInterval   float64 // moving average of the seconds between event arrivals
+	partial              *mergedLogEvent
+	seqNumbers           map[Target]uint64 // last sequence number injected per group and stream
+	metadataFetched      bool
+	metadataOnce         sync.Once
+	providerMetadata     *metadataCache  // the metadata of MetadataProvider, shared with the shards and streams
+	metadata             []metadataField // the EnrichFields of the metadata, in order
+	hostname             string          // host name of the EnvelopeFormat, fetched once
+	dedup                *dedupCache
+	partialStart         time.Time
+	partialTimer         *time.Timer // fires when the held partial event expires
 
 	initNonBlockingChOnce sync.Once
 	startNonBlockCh       chan struct{}
+
+	mirrorOnce sync.Once
+	mirrorCh   chan []string
+
+	activityOnce sync.Once
+	activityCh   chan activityRecord
+
+	retentionOnce sync.Once
+
+	droppedMetricOnce sync.Once
+
+	fieldIndexesOnce sync.Once
+
+	shardsMu sync.Mutex
+	shards   []*pusher
+
+	streamsMu          sync.Mutex
+	streams            map[Target]*streamBatch
+	routed             bool                     // whether the pusher is the one of a routed group and stream, keeping all its events
+	streamsChecked     time.Time                // last check for idle streams
+	streamsClosing     map[Target]chan struct{} // closed once the evicted pusher of the stream is closed
+	streamsWg          sync.WaitGroup
+	evictedStreamStats PusherStats
+
+	errorsCh     chan error
+	errorsMu     sync.Mutex
+	errorsClosed bool
 }
 
+// NewPusher creates a pusher sending the events added to the target with the service, and starts its
+// send loop. It panics when the service is nil, so the misconfiguration fails at once rather than
+// on the first send, in the send loop.
 func NewPusher(target Target, service CloudWatchLogsService, flushTimeout time.Duration, retryDuration time.Duration, logger telegraf.Logger) *pusher {
+	if isNilService(service) {
+		panic(fmt.Sprintf("cloudwatchlogs: NewPusher called with a nil CloudWatchLogsService for %v/%v", target.Group, target.Stream))
+	}
 	p := &pusher{
 		Target:        target,
 		Service:       service,
 		FlushTimeout:  flushTimeout,
 		RetryDuration: retryDuration,
 		Log:           logger,
+		pusherOptions: pusherOptions{
+			FlushJitter: defaultFlushJitter,
+			SortEvents:  true,
+		},
 
 		events:          make([]*cloudwatchlogs.InputLogEvent, 0, 10),
 		eventsCh:        make(chan logs.LogEvent, 100),
 		flushTimer:      time.NewTimer(flushTimeout),
+		curFlushTimeout: flushTimeout,
 		stop:            make(chan struct{}),
+		done:            make(chan struct{}),
 		startNonBlockCh: make(chan struct{}),
+		errorsCh:        make(chan error, errorsChSize),
+		counters:        &pusherCounters{},
+		retryTimer:      newStoppedTimer(),
+		budgetTimer:     newStoppedTimer(),
+		doneTimer:       newStoppedTimer(),
+		heartbeatTimer:  newStoppedTimer(),
+		batchWaitTimer:  newStoppedTimer(),
+		partialTimer:    newStoppedTimer(),
+		startTime:       time.Now(),
+		tickCh:          make(chan struct{}, 1),
 	}
 	go p.start()
 	return p
 }
 
+// isNilService reports whether the service is nil, including a nil pointer of a service type.
+func isNilService(service CloudWatchLogsService) bool {
+	if service == nil {
+		return true
+	}
+	v := reflect.ValueOf(service)
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
+
 func (p *pusher) AddEvent(e logs.LogEvent) {
-	if !hasValidTime(e) {
-		p.Log.Errorf("The log entry in (%v/%v) with timestamp (%v) comparing to the current time (%v) is out of accepted time range. Discard the log entry.", p.Group, p.Stream, e.Time(), time.Now())
+	if p.DetectTimestampUnits {
+		e = p.checkTimestampUnits(e)
+	}
+	if !p.validTime(e) {
+		p.Log.Errorf("The log entry in (%v) with timestamp (%v) comparing to the current time (%v) is out of accepted time range. Discard the log entry.", p.targetName(), e.Time(), time.Now())
+		p.counters.addDropped(1)
+		p.dropped(e)
+		return
+	}
+	// Check the stop first, so the event is not queued behind a stopped loop when the channel has room
+	if p.isStopped() {
+		p.discardStopped()
+		return
+	}
+	p.prefetchMetadata()
+	p.startDroppedMetric()
+	if t := p.eventTarget(e); t != p.target() {
+		p.addStreamEvent(e, t, false)
+		return
+	}
+	if p.sharded() {
+		p.shardFor(e).AddEvent(e)
+		return
+	}
+	if p.SamplingUnderPressure && !p.keepSampled() {
+		p.dropped(e)
 		return
 	}
-	p.eventsCh <- e
+	if p.WAL != nil {
+		e = p.logToWAL(e)
+	}
+	p.enqueue(e)
+}
+
+// enqueue queues the event for the event loop, and reports whether it was queued before the pusher stopped.
+func (p *pusher) enqueue(e logs.LogEvent) bool {
+	// With room in the channel, the select below could still queue the event behind a stopped loop
+	if p.isStopped() {
+		p.discardStopped()
+		return false
+	}
+	select {
+	case p.eventsCh <- e:
+		return true
+	case <-p.stop:
+		p.discardStopped()
+		return false
+	}
 }
 
 func (p *pusher) AddEventNonBlocking(e logs.LogEvent) {
-	if !hasValidTime(e) {
-		p.Log.Errorf("The log entry in (%v/%v) with timestamp (%v) comparing to the current time (%v) is out of accepted time range. Discard the log entry.", p.Group, p.Stream, e.Time(), time.Now())
+	if p.DetectTimestampUnits {
+		e = p.checkTimestampUnits(e)
+	}
+	if !p.validTime(e) {
+		p.Log.Errorf("The log entry in (%v) with timestamp (%v) comparing to the current time (%v) is out of accepted time range. Discard the log entry.", p.targetName(), e.Time(), time.Now())
+		p.counters.addDropped(1)
+		p.dropped(e)
+		return
+	}
+	if p.isStopped() {
+		p.discardStopped()
+		return
+	}
+	p.prefetchMetadata()
+	p.startDroppedMetric()
+	if t := p.eventTarget(e); t != p.target() {
+		p.addStreamEvent(e, t, true)
+		return
+	}
+	if p.sharded() {
+		p.shardFor(e).AddEventNonBlocking(e)
 		return
 	}
 
 	p.initNonBlockingChOnce.Do(func() {
 		p.nonBlockingEventsCh = make(chan logs.LogEvent, reqEventsLimit*2)
-		p.startNonBlockCh <- struct{}{} // Unblock the select loop to recogonize the channel merge
+		// Unblock the select loop to recogonize the channel merge
+		select {
+		case p.startNonBlockCh <- struct{}{}:
+		case <-p.stop:
+		}
 	})
 
 	// Drain the channel until new event can be added
@@ -100,26 +645,184 @@ func (p *pusher) AddEventNonBlocking(e logs.LogEvent) {
 		default:
 			<-p.nonBlockingEventsCh
 			p.addStats("emfMetricDrop", 1)
+			p.counters.addDropped(1)
 		}
 	}
 }
 
-func hasValidTime(e logs.LogEvent) bool {
-	//http://docs.aws.amazon.com/goto/SdkForGoV1/logs-2014-03-28/PutLogEvents
-	//* None of the log events in the batch can be more than 2 hours in the future.
-	//* None of the log events in the batch can be older than 14 days or the retention period of the log group.
-	if !e.Time().IsZero() {
-		now := time.Now()
-		dt := now.Sub(e.Time()).Hours()
-		if dt > 24*14 || dt < -2 {
-			return false
+// Stop stops the pusher after sending the current batch. The events added after Stop are discarded.
+func (p *pusher) Stop() {
+	p.stopOnce.Do(func() { close(p.stop) })
+	if p.sharded() {
+		for _, s := range p.shardPushers() {
+			s.Stop()
 		}
 	}
-	return true
+	for _, s := range p.streamPushers() {
+		s.Stop()
+	}
 }
 
-func (p *pusher) Stop() {
-	close(p.stop)
+func (p *pusher) isStopped() bool {
+	select {
+	case <-p.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *pusher) discardStopped() {
+	p.Log.Warnf("Pusher for %v is stopped, discarding the log event.", p.targetName())
+	p.counters.addDropped(1)
+}
+
+// Close sends the events already added, stops the pusher and waits for the last batch to be flushed,
+// then returns the final stats. Unlike Stop, the events still queued when Close is called are not lost.
+func (p *pusher) Close() (PusherStats, error) {
+	// The shards and streams are closed first, their sends are the events of the pusher
+	var shardErr error
+	if p.sharded() {
+		for _, s := range p.shardPushers() {
+			if _, err := s.Close(); err != nil && shardErr == nil {
+				shardErr = err
+			}
+		}
+	}
+	if err := p.closeStreams(); err != nil && shardErr == nil {
+		shardErr = err
+	}
+
+	drained := make(chan struct{})
+	err := p.control(func() { close(drained) })
+	if err == nil {
+		select {
+		case <-drained:
+			p.Stop()
+		case <-p.stop:
+			err = errPusherStopped
+		}
+	}
+	<-p.done
+	if err == nil {
+		err = shardErr
+	}
+	return p.Stats(), err
+}
+
+// LastSuccessTime returns the time of the last successful PutLogEvents call,
+// or the zero time if nothing has been delivered yet.
+func (p *pusher) LastSuccessTime() time.Time {
+	t, _ := p.lastSuccessTime.Load().(time.Time)
+	return t
+}
+
+// Errors returns a channel receiving the non-retryable delivery errors of the pusher.
+// Delivery is best effort: errors are dropped when the channel buffer is full, so a slow
+// consumer never blocks sending. The channel is closed once the pusher is stopped.
+func (p *pusher) Errors() <-chan error {
+	return p.errorsCh
+}
+
+func (p *pusher) reportError(err error) {
+	p.errorsMu.Lock()
+	defer p.errorsMu.Unlock()
+	if p.errorsClosed {
+		return
+	}
+	select {
+	case p.errorsCh <- fmt.Errorf("failed to send logs to %v: %w", p.targetName(), err):
+	default:
+	}
+}
+
+func (p *pusher) closeErrors() {
+	p.errorsMu.Lock()
+	defer p.errorsMu.Unlock()
+	if !p.errorsClosed {
+		p.errorsClosed = true
+		close(p.errorsCh)
+	}
+}
+
+// SetTarget switches the pusher to a new log group and stream. Events already buffered are sent
+// to the previous target first, then the sequence token is reset and the new group and stream are
+// created as needed. An error creating them is returned, but the pusher still switches to the new target.
+func (p *pusher) SetTarget(t Target) error {
+	if err := validateTarget(t); err != nil {
+		return err
+	}
+
+	result := make(chan error, 1)
+	err := p.control(func() {
+		if len(p.events) > 0 {
+			p.send()
+		}
+		p.Log.Infof("Switching pusher from %v/%v to %v/%v", p.Group, p.Stream, t.Group, t.Stream)
+		p.targetMu.Lock()
+		p.Target = t
+		p.targetMu.Unlock()
+		p.sequenceToken = nil
+		err := p.ensureLogGroupAndStream()
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == cloudwatchlogs.ErrCodeResourceAlreadyExistsException {
+			err = nil
+		}
+		result <- err
+	})
+	if err != nil {
+		return err
+	}
+	select {
+	case err := <-result:
+		return err
+	case <-p.stop:
+		return errPusherStopped
+	}
+}
+
+// target returns the log group and stream of the pusher. The loop, which is the only one switching it,
+// reads Target directly, the other goroutines through target.
+func (p *pusher) target() Target {
+	p.targetMu.RLock()
+	defer p.targetMu.RUnlock()
+	return p.Target
+}
+
+// targetName returns the log group and stream of the pusher as group/stream, for the messages logged
+// out of the loop.
+func (p *pusher) targetName() string {
+	t := p.target()
+	return t.Group + "/" + t.Stream
+}
+
+func validateTarget(t Target) error {
+	if !logGroupNameRegex.MatchString(t.Group) {
+		return fmt.Errorf("invalid log group name %q", t.Group)
+	}
+	if len(t.Stream) == 0 || len(t.Stream) > 512 || strings.ContainsAny(t.Stream, ":*") {
+		return fmt.Errorf("invalid log stream name %q", t.Stream)
+	}
+	return nil
+}
+
+// controlEvent carries a function to run inside the start loop. It goes through eventsCh so it
+// runs after all events added before it.
+type controlEvent struct {
+	f func()
+}
+
+func (c *controlEvent) Message() string { return "" }
+func (c *controlEvent) Time() time.Time { return time.Time{} }
+func (c *controlEvent) Done()           {}
+
+// control runs f inside the start loop, so f can access the pusher state without racing with sends.
+func (p *pusher) control(f func()) error {
+	select {
+	case p.eventsCh <- &controlEvent{f}:
+		return nil
+	case <-p.stop:
+		return errPusherStopped
+	}
 }
 
 func (p *pusher) start() {
@@ -143,157 +846,833 @@ func (p *pusher) start() {
 	for {
 		select {
 		case e := <-ec:
-			// Start timer when first event of the batch is added (happens after a flush timer timeout)
-			if len(p.events) == 0 {
-				p.resetFlushTimer()
+			if c, ok := e.(*controlEvent); ok {
+				c.f()
+				continue
 			}
 
-			ce := p.convertEvent(e)
-			et := time.Unix(*ce.Timestamp/1000, *ce.Timestamp%1000) // Cloudwatch Log Timestamp is in Millisecond
-
-			// A batch of log events in a single request cannot span more than 24 hours.
-			if (p.minT != nil && et.Sub(*p.minT) > 24*time.Hour) || (p.maxT != nil && p.maxT.Sub(et) > 24*time.Hour) {
-				p.send()
-			}
-
-			size := len(*ce.Message) + eventHeaderSize
-			if p.bufferredSize+size > reqSizeLimit || len(p.events) == reqEventsLimit {
-				p.send()
+			if pe, ok := e.(PartialLogEvent); ok && pe.Partial() {
+				p.addPartial(pe)
+				continue
 			}
-
-			if len(p.events) > 0 && *ce.Timestamp < *p.events[len(p.events)-1].Timestamp {
-				p.needSort = true
+			if fm, ok := e.(FlushMarkerLogEvent); ok && fm.FlushMarker() {
+				p.addFlushMarker(fm)
+				continue
 			}
-
-			p.events = append(p.events, ce)
-			p.doneCallbacks = append(p.doneCallbacks, e.Done)
-			p.bufferredSize += size
-			if p.minT == nil || p.minT.After(et) {
-				p.minT = &et
+			if p.partial != nil {
+				e = p.mergePartial(e)
 			}
-			if p.maxT == nil || p.maxT.Before(et) {
-				p.maxT = &et
+			if p.dropDuplicate(e) {
+				continue
 			}
+			p.addToBatch(e)
 
+		case <-p.retryTimer.C:
+			p.processRetryQueue()
+		case <-p.budgetTimer.C:
+			p.sendBudgetHeld(false)
+		case <-p.doneTimer.C:
+			p.callDelayedDones(false)
 		case <-p.flushTimer.C:
-			if time.Since(p.lastSentTime) >= p.FlushTimeout && len(p.events) > 0 {
-				p.send()
-			} else {
-				p.resetFlushTimer()
+			p.armHeartbeat()
+			p.flushTimeoutExpired()
+		case <-p.heartbeatTimer.C:
+			p.heartbeat()
+		case <-p.batchWaitTimer.C:
+			p.batchWaitExpired()
+		case <-p.partialTimer.C:
+			p.flushExpiredPartial()
+		case <-p.tickCh:
+			p.armHeartbeat()
+			if p.ticker != nil && !time.Now().Before(p.flushDeadline) {
+				p.flushTimeoutExpired()
 			}
 		case <-p.stop:
+			p.suppressDones = p.SuppressDoneOnShutdown
+			if p.partial != nil {
+				p.flushPartial()
+			}
+			if p.paused {
+				p.paused = false
+				p.flushPaused()
+			}
 			if len(p.events) > 0 {
 				p.send()
 			}
+			p.sendBudgetHeld(true)
+			p.drainRetryQueue()
+			p.callDelayedDones(true)
+			if p.coordinator != nil {
+				p.coordinator.Unregister(p)
+			}
+			if p.ticker != nil {
+				p.ticker.Unregister(p)
+			}
+			p.closeErrors()
+			close(p.done)
 			return
 		}
 	}
 }
 
+func (p *pusher) eventHeaderSize() int {
+	if p.EventHeaderSize <= 0 || p.EventHeaderSize > reqSizeLimit-p.msgSizeLimit() {
+		return eventHeaderSize
+	}
+	return p.EventHeaderSize
+}
+
+func (p *pusher) msgSizeLimit() int {
+	// Checked against the default header, the header size is checked against the message size limit
+	if p.MsgSizeLimit <= len(truncatedSuffix) || p.MsgSizeLimit+eventHeaderSize > reqSizeLimit {
+		return msgSizeLimit
+	}
+	return p.MsgSizeLimit
+}
+
+func (p *pusher) maxSequenceTokenRetries() int {
+	if p.MaxSequenceTokenRetries <= 0 {
+		return defaultSequenceTokenRetries
+	}
+	return p.MaxSequenceTokenRetries
+}
+
+func (p *pusher) maxBatchEvents() int {
+	if p.MaxBatchEvents <= 0 || p.MaxBatchEvents > reqEventsLimit {
+		return reqEventsLimit
+	}
+	return p.MaxBatchEvents
+}
+
+// warnNoBatchBacklog warns when events queue up behind the per-event requests of the NoBatch mode.
+func (p *pusher) warnNoBatchBacklog() {
+	if len(p.eventsCh) > cap(p.eventsCh)/2 && time.Since(p.lastNoBatchWarnTime) > noBatchWarnInterval {
+		p.Log.Warnf("Events for log '%s/%s' are queuing up while sending each event individually, consider disabling NoBatch for high throughput streams", p.Group, p.Stream)
+		p.lastNoBatchWarnTime = time.Now()
+	}
+}
+
+func (p *pusher) addToBatch(e logs.LogEvent) {
+	if _, chunk := e.(*splitLogEvent); p.SplitOversized && !chunk {
+		// Scrubbed before being split, so neither a secret nor a repeated frame run is cut across chunks
+		message := p.scrub(p.decode(e.Message()))
+		if len(p.ProjectFields) > 0 {
+			message = projectFields(message, p.ProjectFields)
+		}
+		if limit := p.splitLimit(); len(message) > limit {
+			// Unless the limit is too small for the markers, then the message is truncated as without SplitOversized
+			if chunks := splitMessage(message, limit); chunks != nil {
+				p.addSplit(e, chunks)
+				return
+			}
+		}
+	}
+
+	if p.AdaptiveFlush {
+		p.trackArrival()
+	}
+
+	// Start timer when first event of the batch is added (happens after a flush timer timeout)
+	if len(p.events) == 0 {
+		p.resetFlushTimer()
+		p.batchStart = time.Now()
+		p.startBatchWait()
+	}
+
+	ce := p.convertEvent(e)
+	if p.PostConvert != nil {
+		p.PostConvert(ce, e)
+	}
+	et := time.Unix(*ce.Timestamp/1000, *ce.Timestamp%1000) // Cloudwatch Log Timestamp is in Millisecond
+
+	// A batch of log events in a single request cannot span more than 24 hours.
+	if (!p.minT.IsZero() && et.Sub(p.minT) > 24*time.Hour) || (!p.maxT.IsZero() && p.maxT.Sub(et) > 24*time.Hour) {
+		p.send()
+	}
+
+	size := len(*ce.Message) + p.eventHeaderSize()
+	if p.bufferredSize+size > reqSizeLimit || len(p.events) >= p.maxBatchEvents() {
+		p.send()
+	}
+
+	// The event would be sorted before the last chunks of a split event, sent after its first chunks
+	if p.splitTail && p.SortEvents && *ce.Timestamp < p.splitTailTimestamp {
+		p.send()
+	}
+
+	// The batch is only sorted in send when an event was out of order, the count picks the sort.
+	if p.SortEvents && len(p.events) > 0 && *ce.Timestamp < p.lastTimestamp {
+		p.needSort = true
+		p.outOfOrder++
+	}
+	p.lastTimestamp = *ce.Timestamp
+
+	p.trackDeadline(ce, e)
+	p.trackWAL(ce, e)
+	p.events = append(p.events, ce)
+	p.seqs = append(p.seqs, p.nextSeq)
+	if p.PartitionFn != nil {
+		if p.partitions == nil {
+			p.partitions = make(map[uint64]string)
+		}
+		p.partitions[p.nextSeq] = p.partitionKey(e)
+	}
+	p.nextSeq++
+	p.doneCallbacks = append(p.doneCallbacks, e.Done)
+	p.bufferredSize += size
+	if p.minT.IsZero() || p.minT.After(et) {
+		p.minT = et
+	}
+	if p.maxT.IsZero() || p.maxT.Before(et) {
+		p.maxT = et
+	}
+
+	if p.NoBatch {
+		p.send()
+		p.warnNoBatchBacklog()
+	} else if p.startupSync() || p.batchWaitExceeded() {
+		p.send()
+	}
+}
+
+// startupSync reports whether the event just added is one of the StartupSyncEvents sent on their own.
+func (p *pusher) startupSync() bool {
+	if p.StartupSyncEvents <= 0 || p.startupSynced {
+		return false
+	}
+	if p.startupSyncSent >= p.StartupSyncEvents || len(p.eventsCh) > 0 {
+		p.startupSynced = true
+		p.Log.Debugf("Pusher for %v/%v switching to batching after %v events sent on their own", p.Group, p.Stream, p.startupSyncSent)
+		return false
+	}
+	p.startupSyncSent++
+	p.counters.addStartupSync()
+	return true
+}
+
+// FlushMarkerLogEvent is implemented by log events which may mark a logical boundary, such as the end
+// of a transaction. The events up to a marker are sent right away, in a request of their own when they fit.
+type FlushMarkerLogEvent interface {
+	logs.LogEvent
+	FlushMarker() bool
+}
+
+// addFlushMarker sends the batch up to and including the marker, without the marker when DropFlushMarkers is set.
+// A partial event pending is sent first, the marker ends it.
+func (p *pusher) addFlushMarker(e FlushMarkerLogEvent) {
+	if p.partial != nil {
+		p.flushPartial()
+	}
+	if p.DropFlushMarkers {
+		p.dropped(e)
+	} else if !p.dropDuplicate(e) {
+		p.addToBatch(e)
+	}
+	if len(p.events) > 0 && !p.paused {
+		p.send()
+	}
+}
+
+// PartialLogEvent is implemented by log events which may only carry the beginning of a line,
+// e.g. when a chunked reader splits a multi-byte UTF-8 sequence. The pusher holds partial events
+// and merges them with the following event before sending.
+type PartialLogEvent interface {
+	logs.LogEvent
+	Partial() bool
+}
+
+// mergedLogEvent is the result of merging partial events with their continuation.
+type mergedLogEvent struct {
+	msg    string
+	t      time.Time
+	dones  []func()
+	walIDs []uint64
+}
+
+func (m *mergedLogEvent) Message() string { return m.msg }
+func (m *mergedLogEvent) Time() time.Time { return m.t }
+func (m *mergedLogEvent) Done() {
+	for _, done := range m.dones {
+		done()
+	}
+}
+
+func (p *pusher) addPartial(e PartialLogEvent) {
+	p.flushExpiredPartial()
+	if p.partial == nil {
+		p.partial = &mergedLogEvent{t: e.Time()}
+		p.partialStart = time.Now()
+		p.partialTimer.Reset(partialEventTimeout)
+	}
+	p.partial.msg += e.Message()
+	p.partial.dones = append(p.partial.dones, e.Done)
+	p.partial.walIDs = append(p.partial.walIDs, walIDs(e)...)
+	if len(p.partial.msg) >= p.msgSizeLimit() {
+		p.Log.Warnf("Partial log event for log '%s/%s' reached %v bytes without continuation, sending it as is", p.Group, p.Stream, len(p.partial.msg))
+		p.flushPartial()
+	}
+}
+
+func (p *pusher) mergePartial(e logs.LogEvent) logs.LogEvent {
+	m := p.partial
+	p.partial = nil
+	p.partialTimer.Stop()
+	m.msg += e.Message()
+	m.dones = append(m.dones, e.Done)
+	m.walIDs = append(m.walIDs, walIDs(e)...)
+	if m.t.IsZero() {
+		m.t = e.Time()
+	}
+	return m
+}
+
+// flushExpiredPartial sends the held partial event as is when its continuation did not arrive in time,
+// on the partial timer armed when it started.
+func (p *pusher) flushExpiredPartial() {
+	if p.partial != nil && time.Since(p.partialStart) >= partialEventTimeout {
+		p.Log.Warnf("Partial log event for log '%s/%s' did not receive its continuation within %v, sending it as is", p.Group, p.Stream, partialEventTimeout)
+		p.flushPartial()
+	}
+}
+
+func (p *pusher) flushPartial() {
+	m := p.partial
+	p.partial = nil
+	p.partialTimer.Stop()
+	if p.dropDuplicate(m) {
+		return
+	}
+	p.addToBatch(m)
+}
+
 func (p *pusher) reset() {
 	for i := 0; i < len(p.events); i++ {
 		p.events[i] = nil
 	}
 	p.events = p.events[:0]
+	p.batchHeld = false
+	p.seqs = p.seqs[:0]
+	p.partitions = nil
 	for i := 0; i < len(p.doneCallbacks); i++ {
 		p.doneCallbacks[i] = nil
 	}
 	p.doneCallbacks = p.doneCallbacks[:0]
 	p.bufferredSize = 0
 	p.needSort = false
-	p.minT = nil
-	p.maxT = nil
+	p.outOfOrder = 0
+	p.minT = time.Time{}
+	p.maxT = time.Time{}
+	p.splitTail = false
+	p.batchDeadline = time.Time{}
+}
+
+// logBatch is a batch of converted events with their done callbacks, sent in a single PutLogEvents request.
+type logBatch struct {
+	events          []*cloudwatchlogs.InputLogEvent
+	dones           []func()
+	size            int
+	startTime       time.Time // time of the first send attempt
+	retryCount      int
+	nextAttempt     time.Time
+	lastErr         error
+	recreated       bool      // whether the stream was recreated for this batch after it was not found
+	deadline        time.Time // earliest deadline of its events, zero when none has one
+	tokenRejections int       // InvalidSequenceTokenException received for this batch
 }
 
 func (p *pusher) send() {
-	defer p.resetFlushTimer() // Reset the flush timer after sending the request
+	// PutLogEvents rejects a request without events, there is nothing to do
+	if len(p.events) == 0 {
+		return
+	}
+	defer p.restartFlushWindow() // The next batch gets a full flush timeout, whether this one was delivered or not
+	if p.DetectClockSkew {
+		p.estimateSkew()
+	}
 	if p.needSort {
-		sort.Stable(ByTimestamp(p.events))
+		p.sortBatch()
+	}
+
+	b := &logBatch{
+		events:    p.events,
+		dones:     p.doneCallbacks,
+		size:      p.bufferredSize,
+		startTime: time.Now(),
+		deadline:  p.batchDeadline,
+	}
+
+	if p.PartitionFn != nil && p.sendPartitions(b) {
+		return
+	}
+
+	if p.paused {
+		p.holdBatch(b)
+		p.reset()
+		return
+	}
+
+	if p.RetryQueueSize > 0 {
+		// The batch may outlive the buffers, which are reused after reset
+		b.events = append([]*cloudwatchlogs.InputLogEvent(nil), b.events...)
+		b.dones = append(make([]func(), 0, len(b.dones)), b.dones...)
+		p.reset()
+		p.dispatch(b)
+		return
+	}
+
+	p.dispatch(b)
+	p.reset()
+}
+
+// dispatch sends the batch within the byte budget, through the retry queue when enabled.
+// A batch over the request size limit is sent in several requests.
+func (p *pusher) dispatch(b *logBatch) {
+	if batches := p.splitOversizedBatch(b); len(batches) > 1 {
+		for _, sb := range batches {
+			p.dispatch(sb)
+		}
+		return
 	}
+	p.teeBatch(b)
+	if !p.applyByteBudget(b) {
+		return
+	}
+	p.sendBatch(b)
+}
 
+// sendBatch sends the batch through the retry queue when enabled, or retrying inline.
+func (p *pusher) sendBatch(b *logBatch) {
+	if p.RetryQueueSize > 0 {
+		p.sendOrEnqueue(b)
+		return
+	}
+	p.sendWithRetries(b)
+}
+
+// sendWithRetries sends the batch, retrying inline until it is delivered or given up.
+func (p *pusher) sendWithRetries(b *logBatch) {
+	for p.attemptSend(b) {
+		wait := retryWait(b.retryCount)
+		if p.giveUp(b, wait) {
+			return
+		}
+
+		p.Log.Warnf("Retried %v time, going to sleep %v before retrying.", b.retryCount, wait)
+		time.Sleep(wait)
+		b.retryCount++
+	}
+}
+
+// attemptSend makes a single PutLogEvents attempt for the batch, and returns whether it should be retried.
+func (p *pusher) attemptSend(b *logBatch) bool {
 	input := &cloudwatchlogs.PutLogEventsInput{
-		LogEvents:     p.events,
+		LogEvents:     b.events,
 		LogGroupName:  &p.Group,
 		LogStreamName: &p.Stream,
 		SequenceToken: p.sequenceToken,
 	}
 
-	startTime := time.Now()
-
-	retryCount := 0
-	for {
-		input.SequenceToken = p.sequenceToken
-		output, err := p.Service.PutLogEvents(input)
-		if err == nil {
-			if output.NextSequenceToken != nil {
-				p.sequenceToken = output.NextSequenceToken
+	p.counters.addSendMode(input.SequenceToken)
+	start := time.Now()
+	output, err := p.putLogEventsWithEntity(b.deadline, input)
+	latency := time.Since(start)
+	p.recordAttempt(b.events, b.size, b.retryCount, output, err, latency)
+	p.notifySendAttempt(input, b.size, b.retryCount, err, latency)
+	if err == nil {
+		accepted, dones := b.events, b.dones
+		if output.NextSequenceToken != nil {
+			p.updateSequenceToken(output.NextSequenceToken)
+		}
+		if output.RejectedLogEventsInfo != nil {
+			info := output.RejectedLogEventsInfo
+			if info.TooOldLogEventEndIndex != nil {
+				p.Log.Warnf("%d log events for log '%s/%s' are too old", *info.TooOldLogEventEndIndex, p.Group, p.Stream)
 			}
-			if output.RejectedLogEventsInfo != nil {
-				info := output.RejectedLogEventsInfo
-				if info.TooOldLogEventEndIndex != nil {
-					p.Log.Warnf("%d log events for log '%s/%s' are too old", *info.TooOldLogEventEndIndex, p.Group, p.Stream)
-				}
-				if info.TooNewLogEventStartIndex != nil {
-					p.Log.Warnf("%d log events for log '%s/%s' are too new", *info.TooNewLogEventStartIndex, p.Group, p.Stream)
-				}
-				if info.ExpiredLogEventEndIndex != nil {
-					p.Log.Warnf("%d log events for log '%s/%s' are expired", *info.ExpiredLogEventEndIndex, p.Group, p.Stream)
-				}
+			if info.TooNewLogEventStartIndex != nil {
+				p.Log.Warnf("%d log events for log '%s/%s' are too new", *info.TooNewLogEventStartIndex, p.Group, p.Stream)
 			}
-
-			for i := len(p.doneCallbacks) - 1; i >= 0; i-- {
-				done := p.doneCallbacks[i]
-				done()
+			if info.ExpiredLogEventEndIndex != nil {
+				p.Log.Warnf("%d log events for log '%s/%s' are expired", *info.ExpiredLogEventEndIndex, p.Group, p.Stream)
 			}
+			if p.OnReject != nil {
+				p.notifyRejected(info, b.events)
+			}
+			accepted, dones = p.dropRejected(info, b.events, b.dones)
+		}
 
-			p.Log.Debugf("Pusher published %v log events to group: %v stream: %v with size %v KB in %v.", len(p.events), p.Group, p.Stream, p.bufferredSize/1024, time.Since(startTime))
-			p.addStats("rawSize", float64(p.bufferredSize))
+		p.releaseEvents(b.events)
+		p.callDones(dones)
+		p.mirror(accepted)
+		p.auditBatch(b.events, output.NextSequenceToken)
 
-			p.reset()
-			p.lastSentTime = time.Now()
+		p.Log.Debugf("Pusher published %v log events to group: %v stream: %v with size %v KB in %v.", len(b.events), p.Group, p.Stream, b.size/1024, time.Since(b.startTime))
+		p.addStats("rawSize", float64(b.size))
+		p.counters.addSent(len(accepted), b.size, time.Since(b.startTime))
+		p.chargeByteBudget(b.size)
+		p.putFieldIndexes()
 
-			return
+		p.lastSentTime = time.Now()
+		p.lastSuccessTime.Store(p.lastSentTime)
+		p.setState(Healthy)
+
+		return false
+	}
+
+	b.lastErr = err
+	p.counters.addError()
+	awsErr, ok := err.(awserr.Error)
+	if !ok && isRetryableRequestError(err) {
+		p.Log.Warnf("Transient request error received when sending logs to %v/%v, will retry: %v", p.Group, p.Stream, err)
+		p.setState(Retrying)
+		return true
+	}
+	if !ok {
+		p.Log.Errorf("Non aws error received when sending logs to %v: %v", p.destination(), err)
+		p.reportError(err)
+		p.counters.addDropped(len(b.events))
+		p.releaseEvents(b.events)
+		p.setState(CircuitOpen)
+		// Messages will be discarded but done callbacks not called
+		return false
+	}
+
+	if awsErr.Code() == errCodeAssumeRoleFailed {
+		p.Log.Errorf("Unable to get the credentials of role %v to send logs to %v/%v: %v", p.RoleARN, p.Group, p.Stream, awsErr)
+		p.reportError(awsErr)
+		p.setState(Retrying)
+		return true
+	}
+
+	switch e := awsErr.(type) {
+	case *cloudwatchlogs.ResourceNotFoundException:
+		knownStreams.remove(p.streamKey())
+		err := p.createLogGroupAndStream()
+		if err == errCreateThrottled {
+			p.Log.Warnf("Creating log stream %v/%v is rate limited, will retry sending the batch", p.Group, p.Stream)
+			break
+		}
+		if err != nil {
+			p.Log.Errorf("Unable to create log stream %v/%v: %v", p.Group, p.Stream, e.Message())
+			break
+		}
+		// The recreated stream starts without a sequence token, the stale one would only fail the retry.
+		p.sequenceToken = nil
+		if !b.recreated {
+			b.recreated = true
+			p.Log.Infof("Recreated log stream %v/%v, resending the batch", p.Group, p.Stream)
+			return p.attemptSend(b)
+		}
+	case *cloudwatchlogs.InvalidSequenceTokenException:
+		p.Log.Warnf("Invalid SequenceToken used, will use new token and retry: %v", e.Message())
+		if e.ExpectedSequenceToken == nil {
+			p.Log.Errorf("Failed to find sequence token from aws response while sending logs to %v/%v: %v", p.Group, p.Stream, e.Message())
 		}
+		p.updateSequenceToken(e.ExpectedSequenceToken)
+		b.tokenRejections++
+		if b.tokenRejections > p.maxSequenceTokenRetries() {
+			p.Log.Errorf("Concurrent writer detected on log stream %v/%v, its sequence token was rejected %v times", p.Group, p.Stream, b.tokenRejections)
+			p.counters.addConcurrentWriter()
+			return p.applyErrorPolicy(b, ConcurrentWriterError, e)
+		}
+	case *cloudwatchlogs.DataAlreadyAcceptedException:
+		p.Log.Errorf("%v, will not retry the request", e)
+		p.reportError(e)
+		p.releaseEvents(b.events)
+		return false
+	default:
+		return p.applyErrorPolicy(b, errorCategory(awsErr), awsErr)
+	}
+
+	p.setState(Retrying)
+	return true
+}
+
+// RejectReason is the reason CloudWatch Logs rejected some events of an accepted request.
+type RejectReason int
+
+const (
+	TooOld RejectReason = iota
+	TooNew
+	Expired
+)
+
+// notRejected is the reason of the accepted events.
+const notRejected RejectReason = -1
+
+// rejectErrors are the errors the rejected events are dead-lettered with.
+var rejectErrors = map[RejectReason]error{
+	TooOld:  errors.New("log event too old"),
+	TooNew:  errors.New("log event too new"),
+	Expired: errors.New("log event expired"),
+}
+
+func (r RejectReason) String() string {
+	switch r {
+	case TooOld:
+		return "TooOld"
+	case TooNew:
+		return "TooNew"
+	case Expired:
+		return "Expired"
+	}
+	return fmt.Sprintf("RejectReason(%d)", int(r))
+}
 
-		awsErr, ok := err.(awserr.Error)
-		if !ok {
-			p.Log.Errorf("Non aws error received when sending logs to %v/%v: %v", p.Group, p.Stream, err)
-			// Messages will be discarded but done callbacks not called
-			p.reset()
+// notifyRejected maps the indexes of the rejected events info back to the events and calls OnReject.
+// The too old and expired end indexes are exclusive, the too new start index is inclusive.
+func (p *pusher) notifyRejected(info *cloudwatchlogs.RejectedLogEventsInfo, events []*cloudwatchlogs.InputLogEvent) {
+	n := int64(len(events))
+	clamp := func(i int64) int64 {
+		if i < 0 {
+			return 0
+		}
+		if i > n {
+			return n
+		}
+		return i
+	}
+	if info.TooOldLogEventEndIndex != nil {
+		if end := clamp(*info.TooOldLogEventEndIndex); end > 0 {
+			p.OnReject(TooOld, events[:end])
+		}
+	}
+	if info.TooNewLogEventStartIndex != nil {
+		if start := clamp(*info.TooNewLogEventStartIndex); start < n {
+			p.OnReject(TooNew, events[start:])
+		}
+	}
+	if info.ExpiredLogEventEndIndex != nil {
+		if end := clamp(*info.ExpiredLogEventEndIndex); end > 0 {
+			p.OnReject(Expired, events[:end])
+		}
+	}
+}
+
+// rejectReasons returns the reason each of the n events of an accepted request was rejected for, or
+// notRejected. An event in several of the rejected ranges gets the first of TooOld, TooNew and Expired.
+func rejectReasons(info *cloudwatchlogs.RejectedLogEventsInfo, n int) []RejectReason {
+	reasons := make([]RejectReason, n)
+	for i := range reasons {
+		i64 := int64(i)
+		switch {
+		case info.TooOldLogEventEndIndex != nil && i64 < *info.TooOldLogEventEndIndex:
+			reasons[i] = TooOld
+		case info.TooNewLogEventStartIndex != nil && i64 >= *info.TooNewLogEventStartIndex:
+			reasons[i] = TooNew
+		case info.ExpiredLogEventEndIndex != nil && i64 < *info.ExpiredLogEventEndIndex:
+			reasons[i] = Expired
+		default:
+			reasons[i] = notRejected
+		}
+	}
+	return reasons
+}
+
+// dropRejected dead-letters the rejected events of an accepted request, per reason, and returns the
+// accepted events and their done callbacks. The done callbacks of the rejected events are not called.
+func (p *pusher) dropRejected(info *cloudwatchlogs.RejectedLogEventsInfo, events []*cloudwatchlogs.InputLogEvent, dones []func()) ([]*cloudwatchlogs.InputLogEvent, []func()) {
+	reasons := rejectReasons(info, len(events))
+	accepted := make([]*cloudwatchlogs.InputLogEvent, 0, len(events))
+	acceptedDones := make([]func(), 0, len(dones))
+	rejected := make(map[RejectReason][]*cloudwatchlogs.InputLogEvent)
+	for i, e := range events {
+		if reasons[i] == notRejected {
+			accepted = append(accepted, e)
+			acceptedDones = append(acceptedDones, dones[i])
+			continue
+		}
+		rejected[reasons[i]] = append(rejected[reasons[i]], e)
+	}
+	for _, reason := range []RejectReason{TooOld, TooNew, Expired} {
+		if len(rejected[reason]) > 0 {
+			p.deadLetter(rejected[reason], rejectErrors[reason])
+		}
+	}
+	return accepted, acceptedDones
+}
+
+// invalidIsolation accumulates the outcome of isolating the invalid events of a batch.
+type invalidIsolation struct {
+	delivered int
+	err       error // transient error that interrupted the isolation
+	events    []*cloudwatchlogs.InputLogEvent
+	dones     []func()
+}
+
+// isInvalidParameterError returns whether err rejects the content of the request, rather than failing it transiently.
+func isInvalidParameterError(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && errorCategory(awsErr) == InvalidParameterError
+}
+
+// isolateInvalidEvents bisects a batch rejected with rejected, an InvalidParameterException, to find the
+// offending events without sending it again. The offending events are dead-lettered and the valid ones
+// are sent. A transient error interrupts the isolation, the events not yet resolved are then left in iso
+// to go back to the retry path.
+func (p *pusher) isolateInvalidEvents(events []*cloudwatchlogs.InputLogEvent, dones []func(), rejected error, iso *invalidIsolation) {
+	if len(events) == 0 {
+		return
+	}
+	if iso.err != nil {
+		iso.events = append(iso.events, events...)
+		iso.dones = append(iso.dones, dones...)
+		return
+	}
+
+	if rejected == nil {
+		start := time.Now()
+		err := p.putLogEvents(events)
+		if err == nil {
+			p.releaseEvents(events)
+			p.callDones(dones)
+			p.mirror(events)
+			p.auditBatch(events, p.sequenceToken)
+			size := 0
+			for _, e := range events {
+				size += len(*e.Message) + p.eventHeaderSize()
+			}
+			p.counters.addSent(len(events), size, time.Since(start))
+			p.chargeByteBudget(size)
+			iso.delivered += len(events)
 			return
 		}
+		p.counters.addError()
+		if !isInvalidParameterError(err) {
+			p.Log.Warnf("Error received when sending logs to %v/%v while isolating invalid log events, will retry: %v", p.Group, p.Stream, err)
+			iso.err = err
+			iso.events = append(iso.events, events...)
+			iso.dones = append(iso.dones, dones...)
+			return
+		}
+		rejected = err
+	}
 
-		switch e := awsErr.(type) {
-		case *cloudwatchlogs.ResourceNotFoundException:
-			err := p.createLogGroupAndStream()
-			if err != nil {
-				p.Log.Errorf("Unable to create log stream %v/%v: %v", p.Group, p.Stream, e.Message())
+	if len(events) == 1 {
+		p.Log.Errorf("Invalid log event with timestamp %v for %v/%v dropped: %v", *events[0].Timestamp, p.Group, p.Stream, rejected)
+		p.deadLetter(events, rejected)
+		return
+	}
+
+	mid := len(events) / 2
+	p.isolateInvalidEvents(events[:mid], dones[:mid], nil, iso)
+	p.isolateInvalidEvents(events[mid:], dones[mid:], nil, iso)
+}
+
+// putLogEvents sends the events with the current sequence token, replacing the token and
+// retrying when it is reported invalid.
+func (p *pusher) putLogEvents(events []*cloudwatchlogs.InputLogEvent) error {
+	for i := 0; ; i++ {
+		input := &cloudwatchlogs.PutLogEventsInput{
+			LogEvents:     events,
+			LogGroupName:  &p.Group,
+			LogStreamName: &p.Stream,
+			SequenceToken: p.sequenceToken,
+		}
+		p.counters.addSendMode(input.SequenceToken)
+		start := time.Now()
+		output, err := p.putLogEventsWithEntity(time.Time{}, input)
+		if p.AuditWriter != nil || p.OnSendAttempt != nil {
+			latency := time.Since(start)
+			size := 0
+			for _, e := range events {
+				size += len(*e.Message) + p.eventHeaderSize()
 			}
-		case *cloudwatchlogs.InvalidSequenceTokenException:
-			p.Log.Warnf("Invalid SequenceToken used, will use new token and retry: %v", e.Message())
-			if e.ExpectedSequenceToken == nil {
-				p.Log.Errorf("Failed to find sequence token from aws response while sending logs to %v/%v: %v", p.Group, p.Stream, e.Message())
+			p.recordAttempt(events, size, i, output, err, latency)
+			p.notifySendAttempt(input, size, i, err, latency)
+		}
+		if err == nil {
+			if output != nil && output.NextSequenceToken != nil {
+				p.updateSequenceToken(output.NextSequenceToken)
 			}
-			p.sequenceToken = e.ExpectedSequenceToken
-		case *cloudwatchlogs.InvalidParameterException,
-			*cloudwatchlogs.DataAlreadyAcceptedException:
-			p.Log.Errorf("%v, will not retry the request", e)
-			p.reset()
-			return
-		default:
-			p.Log.Errorf("Aws error received when sending logs to %v/%v: %v", p.Group, p.Stream, awsErr)
+			return nil
+		}
+		if e, ok := err.(*cloudwatchlogs.InvalidSequenceTokenException); ok && i < isolateTokenRetries {
+			p.updateSequenceToken(e.ExpectedSequenceToken)
+			continue
 		}
+		return err
+	}
+}
+
+// updateSequenceToken records the sequence token returned by a successful send or expected by a rejected
+// one. A missing token clears the one in use without calling OnSequenceToken.
+func (p *pusher) updateSequenceToken(token *string) {
+	changed := token != nil && (p.sequenceToken == nil || *p.sequenceToken != *token)
+	p.sequenceToken = token
+	if changed && p.OnSequenceToken != nil {
+		p.OnSequenceToken(p.Group, p.Stream, token)
+	}
+}
 
-		wait := retryWait(retryCount)
-		if time.Since(startTime)+wait > p.RetryDuration {
-			p.Log.Errorf("All %v retries to %v/%v failed for PutLogEvents, request dropped.", p.Group, p.Stream, retryCount)
+// giveUp drops the batch when its next attempt, after waiting wait, would exceed RetryDuration with
+// DropAfterRetryDuration, or MaxDeliveryLatency, from its first attempt, and reports whether it did. The
+// events past their deadline are dropped from the batch, which is given up once none is left.
+func (p *pusher) giveUp(b *logBatch, wait time.Duration) bool {
+	if next := time.Now().Add(wait); !b.deadline.IsZero() && next.After(b.deadline) && p.expireDeadlines(b, next) {
+		return true
+	}
+	elapsed := time.Since(b.startTime) + wait
+	switch {
+	case p.MaxDeliveryLatency > 0 && elapsed > p.MaxDeliveryLatency:
+		p.Log.Errorf("Batch to %v/%v not delivered within %v after %v retries, request dropped: %v", p.Group, p.Stream, p.MaxDeliveryLatency, b.retryCount, b.lastErr)
+		p.counters.addAbandoned()
+		p.deadLetter(b.events, errDeliveryLatencyExceeded)
+	case elapsed > p.RetryDuration:
+		if !p.DropAfterRetryDuration {
+			p.Log.Errorf("Retries to %v/%v failed for PutLogEvents for over %v, will keep retrying: %v", p.Group, p.Stream, p.RetryDuration, b.lastErr)
+			return false
 		}
+		p.Log.Errorf("All %v retries to %v/%v failed for PutLogEvents, request dropped.", b.retryCount, p.Group, p.Stream)
+		p.deadLetter(b.events, b.lastErr)
+	default:
+		return false
+	}
+	p.setState(CircuitOpen)
+	return true
+}
 
-		p.Log.Warnf("Retried %v time, going to sleep %v before retrying.", retryCount, wait)
-		time.Sleep(wait)
-		retryCount++
+// releaseEvents releases the WAL entries and deadlines of the batched events once they are delivered or dropped.
+func (p *pusher) releaseEvents(events []*cloudwatchlogs.InputLogEvent) {
+	p.releaseWAL(events)
+	p.releaseDeadlines(events)
+}
+
+func (p *pusher) deadLetter(events []*cloudwatchlogs.InputLogEvent, err error) {
+	p.counters.addDropped(len(events))
+	p.releaseEvents(events)
+	p.archiveOverflow(events)
+	if p.DeadLetter != nil {
+		p.DeadLetter(p.Group, p.Stream, events, err)
 	}
+}
 
+// retryableStatusCodes are the HTTP status codes of the transient server failures.
+var retryableStatusCodes = map[int]bool{500: true, 502: true, 503: true, 504: true}
+
+// isRetryableRequestError reports whether the error, or an error it wraps, is a transient request failure:
+// a retryable HTTP status code, a timeout or a request error of the SDK. Such errors may reach the pusher
+// wrapped by the service rather than as a CloudWatch Logs exception.
+func isRetryableRequestError(err error) bool {
+	for err != nil {
+		if rf, ok := err.(awserr.RequestFailure); ok && retryableStatusCodes[rf.StatusCode()] {
+			return true
+		}
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return true
+		}
+		switch e := err.(type) {
+		case interface{ Unwrap() error }:
+			err = e.Unwrap()
+		case awserr.Error:
+			if e.Code() == request.ErrCodeRequestError || e.Code() == request.ErrCodeResponseTimeout {
+				return true
+			}
+			err = e.OrigErr()
+		default:
+			return false
+		}
+	}
+	return false
 }
 
 func retryWait(n int) time.Duration {
@@ -306,7 +1685,76 @@ func retryWait(n int) time.Duration {
 	return time.Duration(seededRand.Int63n(int64(d/2)) + int64(d/2))
 }
 
+// ensureLogGroupAndStream creates the log group and stream unless they are known to exist, from the
+// knownStreams cache or by describing the stream.
+func (p *pusher) ensureLogGroupAndStream() error {
+	if knownStreams.has(p.streamKey()) || p.describeLogStream() {
+		return nil
+	}
+	return p.createLogGroupAndStream()
+}
+
 func (p *pusher) createLogGroupAndStream() error {
+	err := p.createLogGroupAndStreamUncached()
+	if err == nil {
+		p.recordStreamCreated()
+	}
+	if awsErr, ok := err.(awserr.Error); err == nil || ok && awsErr.Code() == cloudwatchlogs.ErrCodeResourceAlreadyExistsException {
+		knownStreams.add(p.streamKey())
+		if err != nil && p.ValidateExistingStream {
+			p.validateExistingStream()
+		}
+	}
+	return err
+}
+
+func (p *pusher) recordStreamCreated() {
+	now := time.Now()
+	p.streamCreatedTime.Store(now)
+	p.Log.Infof("Created log stream %v/%v at %v", p.Group, p.Stream, now.Format(time.RFC3339Nano))
+}
+
+// StreamCreatedTime returns when the pusher last created its log stream, or the zero time
+// if the stream was not created by this pusher.
+func (p *pusher) StreamCreatedTime() time.Time {
+	t, _ := p.streamCreatedTime.Load().(time.Time)
+	return t
+}
+
+// describeLogStream checks whether the log stream exists, recording it in the knownStreams cache if it does.
+// An existing stream is validated when ValidateExistingStream is set.
+func (p *pusher) describeLogStream() bool {
+	describer, ok := p.Service.(logStreamDescriber)
+	if !ok {
+		return false
+	}
+	output, err := describer.DescribeLogStreams(&cloudwatchlogs.DescribeLogStreamsInput{
+		LogGroupName:        &p.Group,
+		LogStreamNamePrefix: &p.Stream,
+	})
+	if err != nil {
+		p.Log.Debugf("describing stream %v/%v fail due to : %v", p.Group, p.Stream, err)
+		return false
+	}
+	stream := findLogStream(output, p.Stream)
+	if stream == nil {
+		return false
+	}
+	knownStreams.add(p.streamKey())
+	if p.ValidateExistingStream {
+		p.checkExistingStream(stream, time.Now())
+	}
+	return true
+}
+
+func (p *pusher) createLogGroupAndStreamUncached() error {
+	if !p.LogGroupManager.acquireCreate(p.stop) {
+		return errPusherStopped
+	}
+	defer p.LogGroupManager.releaseCreate()
+	if !p.LogGroupManager.allowCreate() {
+		return errCreateThrottled
+	}
 	_, err := p.Service.CreateLogStream(&cloudwatchlogs.CreateLogStreamInput{
 		LogGroupName:  &p.Group,
 		LogStreamName: &p.Stream,
@@ -315,16 +1763,24 @@ func (p *pusher) createLogGroupAndStream() error {
 	if err != nil {
 		p.Log.Debugf("creating stream fail due to : %v \n", err)
 		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == cloudwatchlogs.ErrCodeResourceNotFoundException {
+			if !p.LogGroupManager.allowCreate() {
+				return errCreateThrottled
+			}
 			_, err = p.Service.CreateLogGroup(&cloudwatchlogs.CreateLogGroupInput{
 				LogGroupName: &p.Group,
 			})
 
+			// A concurrent creator may have created the group already, which is fine for creating the stream.
+			if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == cloudwatchlogs.ErrCodeResourceAlreadyExistsException {
+				p.Log.Debugf("log group %v already exists", p.Group)
+				err = nil
+			}
+
 			// create stream again if group created successfully.
 			if err == nil {
-				_, err = p.Service.CreateLogStream(&cloudwatchlogs.CreateLogStreamInput{
-					LogGroupName:  &p.Group,
-					LogStreamName: &p.Stream,
-				})
+				p.putFieldIndexes()
+				p.putMetricFilters()
+				err = p.createLogStreamAfterGroup()
 			} else {
 				p.Log.Errorf("creating group fail due to : %v \n", err)
 			}
@@ -334,19 +1790,164 @@ func (p *pusher) createLogGroupAndStream() error {
 	return err
 }
 
+// createLogStreamAfterGroup creates the log stream right after its group has been created (or found to exist).
+// The group may not be fully consistent yet, so a ResourceNotFoundException is retried a few times.
+func (p *pusher) createLogStreamAfterGroup() error {
+	var err error
+	for i := 0; i < createStreamRetries; i++ {
+		if i > 0 {
+			p.Log.Debugf("log group %v is not ready yet, retrying to create stream %v in %v", p.Group, p.Stream, createStreamRetryWait)
+			time.Sleep(createStreamRetryWait)
+		}
+		if !p.LogGroupManager.allowCreate() {
+			return errCreateThrottled
+		}
+		_, err = p.Service.CreateLogStream(&cloudwatchlogs.CreateLogStreamInput{
+			LogGroupName:  &p.Group,
+			LogStreamName: &p.Stream,
+		})
+		if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != cloudwatchlogs.ErrCodeResourceNotFoundException {
+			return err
+		}
+	}
+	return err
+}
+
+// trackArrival updates the moving average of the interval between event arrivals.
+func (p *pusher) trackArrival() {
+	now := time.Now()
+	if !p.lastArrivalTime.IsZero() {
+		dt := now.Sub(p.lastArrivalTime).Seconds()
+		p.avgArrivalInterval = arrivalIntervalWeight*dt + (1-arrivalIntervalWeight)*p.avgArrivalInterval
+	}
+	p.lastArrivalTime = now
+}
+
+// flushTimeout returns the effective flush timeout, adjusted to the arrival rate in adaptive mode.
+func (p *pusher) flushTimeout() time.Duration {
+	if !p.AdaptiveFlush || p.MaxFlushTimeout <= p.MinFlushTimeout {
+		return p.FlushTimeout
+	}
+	if p.avgArrivalInterval <= 0 {
+		return p.MinFlushTimeout
+	}
+	rate := 1 / p.avgArrivalInterval
+	ratio := rate / (rate + adaptiveFlushHalfRate)
+	return p.MinFlushTimeout + time.Duration(ratio*float64(p.MaxFlushTimeout-p.MinFlushTimeout))
+}
+
+// jitteredFlushTimeout applies FlushJitter to the flush timeout, bounded by MinFlushTimeout and MaxFlushTimeout.
+func (p *pusher) jitteredFlushTimeout() time.Duration {
+	d := p.flushTimeout()
+	if p.FlushJitter <= 0 {
+		return d
+	}
+	jitterMu.Lock()
+	r := seededRand.Float64()
+	jitterMu.Unlock()
+	d += time.Duration((2*r - 1) * p.FlushJitter * float64(d))
+	if p.MaxFlushTimeout > 0 && d > p.MaxFlushTimeout {
+		d = p.MaxFlushTimeout
+	}
+	if d < p.MinFlushTimeout {
+		d = p.MinFlushTimeout
+	}
+	return d
+}
+
+// flushTimeoutExpired sends the batch once the flush timeout expired since the last send.
+func (p *pusher) flushTimeoutExpired() {
+	// While paused, the batch is only held once full, so small batches do not pile up
+	if !p.paused && time.Since(p.lastFlushTime) >= p.curFlushTimeout && len(p.events) > 0 {
+		if !p.holdSmallBatch() {
+			p.send()
+		}
+	} else {
+		p.resetFlushTimer()
+	}
+}
+
+// holdSmallBatch extends the flush timer of a batch under MinBatchEvents, once and up to MaxBatchAge.
+// It reports whether the batch is held.
+func (p *pusher) holdSmallBatch() bool {
+	if len(p.events) >= p.MinBatchEvents || p.batchHeld {
+		return false
+	}
+	wait := p.curFlushTimeout
+	if p.MaxBatchAge > 0 {
+		if left := p.MaxBatchAge - time.Since(p.batchStart); left < wait {
+			wait = left
+		}
+	}
+	if wait <= 0 {
+		return false
+	}
+	p.batchHeld = true
+	p.flushTimer.Stop()
+	if p.ticker != nil {
+		p.flushDeadline = time.Now().Add(wait)
+	} else {
+		p.flushTimer.Reset(wait)
+	}
+	return true
+}
+
+// restartFlushWindow starts the flush window of the next batch once a batch was sent, delivered or not.
+// A flush timer which expired while the send blocked the event loop, such as during its retries, may still
+// fire right after it: the window it checks keeps the new batch from being sent with its first events only.
+func (p *pusher) restartFlushWindow() {
+	p.lastFlushTime = time.Now()
+	p.resetFlushTimer()
+}
+
 func (p *pusher) resetFlushTimer() {
 	p.flushTimer.Stop()
-	p.flushTimer.Reset(p.FlushTimeout)
+	if p.coordinator != nil {
+		p.curFlushTimeout = p.coordinator.nextFlush(p, p.flushTimeout())
+	} else {
+		p.curFlushTimeout = p.jitteredFlushTimeout()
+	}
+	if p.ticker != nil {
+		p.flushDeadline = time.Now().Add(p.curFlushTimeout)
+		return
+	}
+	p.flushTimer.Reset(p.curFlushTimeout)
 }
 
 func (p *pusher) convertEvent(e logs.LogEvent) *cloudwatchlogs.InputLogEvent {
-	message := e.Message()
+	message := p.decode(e.Message())
+	// The chunks of a split event are cut from its scrubbed message
+	if _, chunk := e.(*splitLogEvent); !chunk {
+		message = p.scrub(message)
+	}
 
-	if len(message) > msgSizeLimit {
-		message = message[:msgSizeLimit-len(truncatedSuffix)] + truncatedSuffix
+	if len(p.ProjectFields) > 0 {
+		message = projectFields(message, p.ProjectFields)
+	}
+	if len(p.EnrichFields) > 0 {
+		message = p.enrich(message)
+	}
+	if p.EnvelopeFormat != nil {
+		message = p.wrapEnvelope(message, e.Time())
+	}
+	if p.InjectSequenceNumber {
+		message = p.injectSequenceNumber(message)
+	}
+	var ingest time.Time
+	if p.TimestampSource == IngestTime || p.EmbedOtherTime {
+		ingest = ingestTime(e)
+	}
+	if p.EmbedOtherTime {
+		message = p.embedOtherTime(message, e.Time(), ingest)
+	}
+
+	if len(message) > p.msgSizeLimit() {
+		message = p.truncate(message)
 	}
 	var t int64
-	if e.Time().IsZero() {
+	if p.TimestampSource == IngestTime {
+		t = ingest.UnixNano() / 1000000
+	} else if e.Time().IsZero() {
 		if p.lastValidTime != 0 {
 			// Where there has been a valid time before, assume most log events would have
 			// a valid timestamp and use the last valid timestamp for new entries that does
@@ -358,6 +1959,12 @@ func (p *pusher) convertEvent(e logs.LogEvent) *cloudwatchlogs.InputLogEvent {
 	} else {
 		t = e.Time().UnixNano() / 1000000
 		p.lastValidTime = t
+		if p.DetectClockSkew {
+			t = p.observeSkew(e.Time(), t)
+		}
+	}
+	if r := int64(p.TimestampRounding / time.Millisecond); r > 1 {
+		t -= t % r
 	}
 	return &cloudwatchlogs.InputLogEvent{
 		Message:   &message,
@@ -365,6 +1972,79 @@ func (p *pusher) convertEvent(e logs.LogEvent) *cloudwatchlogs.InputLogEvent {
 	}
 }
 
+// scrub sanitizes, redacts and collapses the repeated frames of the message as configured.
+func (p *pusher) scrub(message string) string {
+	if p.SanitizeControlChars {
+		message = p.sanitize(message)
+	}
+	if len(p.RedactionRules) > 0 || p.Redactor != nil {
+		message = p.redact(message)
+	}
+	if p.CollapseRepeatedFrames {
+		message = p.collapseFrames(message)
+	}
+	return message
+}
+
+// maxSeqPrefixSize is the size of the longest prefix injected by InjectSequenceNumber.
+var maxSeqPrefixSize = len(fmt.Sprintf("[seq:%d] ", uint64(math.MaxUint64)))
+
+// injectSequenceNumber prefixes the message with the next sequence number of the group and stream.
+func (p *pusher) injectSequenceNumber(message string) string {
+	if p.seqNumbers == nil {
+		p.seqNumbers = make(map[Target]uint64)
+	}
+	t := Target{Group: p.Group, Stream: p.Stream}
+	p.seqNumbers[t]++
+	return fmt.Sprintf("[seq:%d] %v", p.seqNumbers[t], message)
+}
+
+// projectFields keeps only the given top-level keys of a JSON object message.
+func projectFields(message string, fields []string) string {
+	if !strings.HasPrefix(strings.TrimSpace(message), "{") {
+		return message
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(message), &obj); err != nil {
+		return message
+	}
+	projected := make(map[string]json.RawMessage, len(fields))
+	for _, f := range fields {
+		if v, ok := obj[f]; ok {
+			projected[f] = v
+		}
+	}
+	b, err := json.Marshal(projected)
+	if err != nil {
+		return message
+	}
+	return string(b)
+}
+
+// truncate cuts the message to the size limit on a rune boundary, marked by TruncateMarker. The truncated
+// prefix is shortened by what the marker adds over the limit, the marked message is only cut when it still
+// does not fit.
+func (p *pusher) truncate(message string) string {
+	limit := p.msgSizeLimit()
+	truncated := truncateUTF8(message, limit-len(truncatedSuffix))
+	if p.TruncateMarker == nil {
+		return truncated + truncatedSuffix
+	}
+	marked := p.TruncateMarker(message, truncated)
+	for i := 0; i < maxTruncateMarkerTries && len(marked) > limit && len(truncated) > 0; i++ {
+		truncated = truncateUTF8(truncated, len(truncated)-(len(marked)-limit))
+		marked = p.TruncateMarker(message, truncated)
+	}
+	if len(marked) > limit {
+		if time.Since(p.lastTruncateWarnTime) > truncateWarnInterval {
+			p.Log.Warnf("Truncated message for log '%s/%s' is still too long after applying the truncate marker, cut to %v bytes", p.Group, p.Stream, limit)
+			p.lastTruncateWarnTime = time.Now()
+		}
+		marked = truncateUTF8(marked, limit)
+	}
+	return marked
+}
+
 func (p *pusher) addStats(statsName string, value float64) {
 	statsKey := []string{"cloudwatchlogs", p.Group, statsName}
 	profiler.Profiler.AddStats(statsKey, value)
@@ -383,3 +2063,51 @@ func (inputLogEvents ByTimestamp) Swap(i, j int) {
 func (inputLogEvents ByTimestamp) Less(i, j int) bool {
 	return *inputLogEvents[i].Timestamp < *inputLogEvents[j].Timestamp
 }
+
+// sortBatch sorts the buffered events by timestamp. Small or nearly sorted batches, typically with a few
+// late events, are sorted in place by insertion, which is faster and does not allocate. Heavily unsorted
+// batches use the sort package, as well as the batches with few but far out of order events, once the
+// insertion moved the events too many times. The order is the same either way, equal timestamps keep
+// the arrival order.
+func (p *pusher) sortBatch() {
+	n := len(p.events)
+	b := &byTimestampSeq{p.events, p.seqs, p.doneCallbacks}
+	if n > insertionSortMaxEvents && p.outOfOrder > insertionSortMaxOutOfOrder {
+		sort.Sort(b)
+		return
+	}
+	swaps := n * insertionSortMaxSwapsPerEvent
+	for i := 1; i < n; i++ {
+		for j := i; j > 0 && b.Less(j, j-1); j-- {
+			b.Swap(j, j-1)
+			swaps--
+		}
+		if swaps < 0 && n > insertionSortMaxEvents {
+			sort.Sort(b)
+			return
+		}
+	}
+}
+
+// byTimestampSeq sorts the events by timestamp then insertion sequence, so the events with the same
+// timestamp keep their arrival order whatever the sort algorithm.
+type byTimestampSeq struct {
+	events []*cloudwatchlogs.InputLogEvent
+	seqs   []uint64
+	dones  []func() // kept in the order of the events, for the events rejected after sending
+}
+
+func (b *byTimestampSeq) Len() int {
+	return len(b.events)
+}
+
+func (b *byTimestampSeq) Swap(i, j int) {
+	b.events[i], b.events[j] = b.events[j], b.events[i]
+	b.seqs[i], b.seqs[j] = b.seqs[j], b.seqs[i]
+	b.dones[i], b.dones[j] = b.dones[j], b.dones[i]
+}
+
+func (b *byTimestampSeq) Less(i, j int) bool {
+	ti, tj := *b.events[i].Timestamp, *b.events[j].Timestamp
+	return ti < tj || (ti == tj && b.seqs[i] < b.seqs[j])
+}
@@ -0,0 +1,54 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+//go:build prometheus
+// +build prometheus
+
+package cloudwatchlogs
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RegisterMetrics registers Prometheus collectors backed by the pusher counters. The log group and
+// stream are added to the given constant labels, so pushers of different targets can share a registry.
+// It is only built with the prometheus build tag, to keep the dependency optional.
+func (p *pusher) RegisterMetrics(reg prometheus.Registerer, labels prometheus.Labels) error {
+	t := p.target()
+	constLabels := prometheus.Labels{"log_group": t.Group, "log_stream": t.Stream}
+	for k, v := range labels {
+		constLabels[k] = v
+	}
+
+	counter := func(name, help string, value func(PusherStats) int64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace:   "cloudwatchlogs",
+			Subsystem:   "pusher",
+			Name:        name,
+			Help:        help,
+			ConstLabels: constLabels,
+		}, func() float64 { return float64(value(p.Stats())) })
+	}
+
+	collectors := []prometheus.Collector{
+		counter("events_sent_total", "Number of log events delivered.", func(s PusherStats) int64 { return s.EventsSent }),
+		counter("events_dropped_total", "Number of log events dropped.", func(s PusherStats) int64 { return s.EventsDropped }),
+		counter("bytes_sent_total", "Number of bytes delivered.", func(s PusherStats) int64 { return s.BytesSent }),
+		counter("batches_total", "Number of PutLogEvents requests delivered.", func(s PusherStats) int64 { return s.Batches }),
+		counter("errors_total", "Number of failed PutLogEvents requests.", func(s PusherStats) int64 { return s.Errors }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   "cloudwatchlogs",
+			Subsystem:   "pusher",
+			Name:        "last_send_latency_seconds",
+			Help:        "Latency of the last delivered PutLogEvents request.",
+			ConstLabels: constLabels,
+		}, func() float64 { return p.Stats().LastLatency.Seconds() }),
+	}
+
+	for _, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
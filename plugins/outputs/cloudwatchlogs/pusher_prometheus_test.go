@@ -0,0 +1,57 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+//go:build prometheus
+// +build prometheus
+
+package cloudwatchlogs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/influxdata/telegraf/models"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRegisterMetrics(t *testing.T) {
+	var s svcMock
+	nst := "NEXT_SEQ_TOKEN"
+	s.ple = func(in *cloudwatchlogs.PutLogEventsInput) (*cloudwatchlogs.PutLogEventsOutput, error) {
+		return &cloudwatchlogs.PutLogEventsOutput{NextSequenceToken: &nst}, nil
+	}
+
+	p := NewPusher(Target{Group: "G", Stream: "S"}, &s, 1*time.Hour, maxRetryTimeout, models.NewLogger("cloudwatchlogs", "test", ""))
+	reg := prometheus.NewRegistry()
+	if err := p.RegisterMetrics(reg, prometheus.Labels{"host": "h"}); err != nil {
+		t.Fatalf("RegisterMetrics returned unexpected error: %v", err)
+	}
+
+	p.AddEvent(evtMock{"msg", time.Now(), nil})
+	time.Sleep(10 * time.Millisecond)
+	p.send()
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather returned unexpected error: %v", err)
+	}
+	found := false
+	for _, mf := range mfs {
+		if mf.GetName() == "cloudwatchlogs_pusher_events_sent_total" {
+			found = true
+			if v := mf.GetMetric()[0].GetCounter().GetValue(); v != 1 {
+				t.Errorf("Expecting 1 event sent, got %v", v)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("events_sent_total metric was not registered")
+	}
+
+	// Registering the same pusher twice should fail instead of silently duplicating the collectors
+	if err := p.RegisterMetrics(reg, prometheus.Labels{"host": "h"}); err == nil {
+		t.Errorf("RegisterMetrics should fail when the collectors are already registered")
+	}
+	p.Stop()
+}
@@ -0,0 +1,49 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// PusherState is the delivery state of a pusher, derived from its recent sends.
+type PusherState int32
+
+const (
+	// Healthy is the state until a send fails, and again after a successful send.
+	Healthy PusherState = iota
+	// Retrying is the state while a failed batch is being retried.
+	Retrying
+	// CircuitOpen is the state once a batch was dropped after failing, until a send succeeds.
+	CircuitOpen
+	// Stopped is the state once the pusher is stopped.
+	Stopped
+)
+
+func (s PusherState) String() string {
+	switch s {
+	case Healthy:
+		return "Healthy"
+	case Retrying:
+		return "Retrying"
+	case CircuitOpen:
+		return "CircuitOpen"
+	case Stopped:
+		return "Stopped"
+	}
+	return fmt.Sprintf("PusherState(%d)", int32(s))
+}
+
+// State returns the current delivery state of the pusher, it is safe to call concurrently with sends.
+func (p *pusher) State() PusherState {
+	if p.isStopped() {
+		return Stopped
+	}
+	return PusherState(atomic.LoadInt32(&p.state))
+}
+
+func (p *pusher) setState(s PusherState) {
+	atomic.StoreInt32(&p.state, int32(s))
+}
@@ -0,0 +1,184 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// PusherStats is a snapshot of the counters of a pusher since it was created.
+type PusherStats struct {
+	EventsSent    int64
+	EventsDropped int64
+	BytesSent     int64
+	Batches       int64
+	Errors        int64 // failed PutLogEvents calls, including the ones retried
+	LastLatency   time.Duration
+	// BudgetRemaining is the number of bytes left in the current byte budget window, 0 without a budget.
+	BudgetRemaining int64
+	// ClockSkew is the sustained offset of the event times ahead of the current time, 0 when not detected.
+	ClockSkew time.Duration
+	// BatchesAbandoned is the number of batches dropped for exceeding MaxDeliveryLatency.
+	BatchesAbandoned int64
+	// SampledOut is the number of events dropped by SamplingUnderPressure, and SamplingRate the current
+	// rate: 1 in SamplingRate events are kept, all of them when it is 1.
+	SampledOut   int64
+	SamplingRate int64
+	// Duplicates is the number of events dropped by DedupTTL.
+	Duplicates int64
+	// Sanitized is the number of messages whose control characters were replaced by SanitizeControlChars.
+	Sanitized int64
+	// StartupSyncSends is the number of StartupSyncEvents sent on their own.
+	StartupSyncSends int64
+	// ConcurrentWriters is the number of batches given up after more than MaxSequenceTokenRetries
+	// sequence token rejections, as another writer sends to the stream.
+	ConcurrentWriters int64
+	// MixedTimestampUnits is the number of events whose time was detected in the wrong unit by DetectTimestampUnits.
+	MixedTimestampUnits int64
+	// EventsArchived is the number of events passed to the ArchiveSink.
+	EventsArchived int64
+	// TokenedSends and TokenlessSends are the numbers of PutLogEvents calls made with and without a sequence
+	// token, retries included, to confirm the sends moved off the sequence tokens.
+	TokenedSends   int64
+	TokenlessSends int64
+	// Redacted is the number of messages changed by RedactionRules or Redactor.
+	Redacted int64
+	// DedupEntries is the number of message hashes cached by DedupTTL, up to DedupCacheSize, and RoutedStreams
+	// the number of log groups and streams batched apart, up to MaxStreams. KnownStreams is the number of log
+	// streams known to exist, shared by all the pushers of the process, up to the size of SetStreamCacheSize.
+	DedupEntries  int64
+	RoutedStreams int64
+	KnownStreams  int64
+	// Region and Endpoint are the CloudWatch Logs endpoint the pusher sends to, empty when not an SDK client.
+	Region   string
+	Endpoint string
+}
+
+// pusherCounters holds the counters updated atomically by the pusher.
+// It is allocated on its own so the 64-bit fields stay aligned for atomic access.
+type pusherCounters struct {
+	eventsSent    int64
+	eventsDropped int64
+	bytesSent     int64
+	batches       int64
+	errors        int64
+	lastLatency   int64
+
+	// The byte budget window is updated by the send loop and read by Stats
+	budgetWindowStart int64 // unix nanoseconds
+	budgetUsed        int64
+
+	clockSkew int64
+
+	batchesAbandoned int64
+
+	// The sampling state is shared by the goroutines adding events
+	pressureSince int64 // unix nanoseconds since the events queue is above the high-water mark, 0 when under
+	samplingRate  int64 // 0 when not sampling
+	sampleSeq     int64
+	sampledOut    int64
+
+	duplicates int64
+	sanitized  int64
+
+	retentionDays int64 // retention of the log group, 0 when unknown or unlimited
+
+	startupSyncSends int64
+
+	concurrentWriters int64
+
+	mixedTimestampUnits int64
+
+	eventsArchived int64
+
+	tokenedSends   int64
+	tokenlessSends int64
+
+	redacted int64
+
+	dedupEntries int64 // updated by the event loop, which owns the dedup cache
+}
+
+// Stats returns a snapshot of the pusher counters, it is safe to call concurrently with sends.
+func (p *pusher) Stats() PusherStats {
+	c := p.counters
+	stats := PusherStats{
+		EventsSent:    atomic.LoadInt64(&c.eventsSent),
+		EventsDropped: atomic.LoadInt64(&c.eventsDropped),
+		BytesSent:     atomic.LoadInt64(&c.bytesSent),
+		Batches:       atomic.LoadInt64(&c.batches),
+		Errors:        atomic.LoadInt64(&c.errors),
+		LastLatency:   time.Duration(atomic.LoadInt64(&c.lastLatency)),
+
+		BudgetRemaining:   p.byteBudgetRemaining(),
+		ClockSkew:         time.Duration(atomic.LoadInt64(&c.clockSkew)),
+		BatchesAbandoned:  atomic.LoadInt64(&c.batchesAbandoned),
+		SampledOut:        atomic.LoadInt64(&c.sampledOut),
+		SamplingRate:      1,
+		Duplicates:        atomic.LoadInt64(&c.duplicates),
+		Sanitized:         atomic.LoadInt64(&c.sanitized),
+		StartupSyncSends:  atomic.LoadInt64(&c.startupSyncSends),
+		ConcurrentWriters: atomic.LoadInt64(&c.concurrentWriters),
+
+		MixedTimestampUnits: atomic.LoadInt64(&c.mixedTimestampUnits),
+		EventsArchived:      atomic.LoadInt64(&c.eventsArchived),
+		TokenedSends:        atomic.LoadInt64(&c.tokenedSends),
+		TokenlessSends:      atomic.LoadInt64(&c.tokenlessSends),
+		Redacted:            atomic.LoadInt64(&c.redacted),
+		DedupEntries:        atomic.LoadInt64(&c.dedupEntries),
+		KnownStreams:        int64(knownStreams.len()),
+	}
+	if rate := atomic.LoadInt64(&c.samplingRate); rate > 0 {
+		stats.SamplingRate = rate
+	}
+	stats.Region, stats.Endpoint = p.Endpoint()
+	p.addShardStats(&stats)
+	p.addStreamStats(&stats)
+	return stats
+}
+
+func (c *pusherCounters) addSent(events, bytes int, latency time.Duration) {
+	atomic.AddInt64(&c.eventsSent, int64(events))
+	atomic.AddInt64(&c.bytesSent, int64(bytes))
+	atomic.AddInt64(&c.batches, 1)
+	atomic.StoreInt64(&c.lastLatency, int64(latency))
+}
+
+func (c *pusherCounters) addDropped(events int) {
+	atomic.AddInt64(&c.eventsDropped, int64(events))
+}
+
+func (c *pusherCounters) addAbandoned() {
+	atomic.AddInt64(&c.batchesAbandoned, 1)
+}
+
+func (c *pusherCounters) addDuplicate() {
+	atomic.AddInt64(&c.duplicates, 1)
+}
+
+func (c *pusherCounters) addSanitized() {
+	atomic.AddInt64(&c.sanitized, 1)
+}
+
+func (c *pusherCounters) addStartupSync() {
+	atomic.AddInt64(&c.startupSyncSends, 1)
+}
+
+func (c *pusherCounters) addConcurrentWriter() {
+	atomic.AddInt64(&c.concurrentWriters, 1)
+}
+
+// addSendMode counts a PutLogEvents call by whether it includes a sequence token.
+func (c *pusherCounters) addSendMode(token *string) {
+	if token != nil {
+		atomic.AddInt64(&c.tokenedSends, 1)
+	} else {
+		atomic.AddInt64(&c.tokenlessSends, 1)
+	}
+}
+
+func (c *pusherCounters) addError() {
+	atomic.AddInt64(&c.errors, 1)
+}
@@ -0,0 +1,91 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"regexp"
+	"sync/atomic"
+)
+
+// RedactionRule replaces the matches of Pattern in the messages with Replacement, which may refer to
+// the submatches of Pattern as in regexp.ReplaceAllString. When Valid is set, only the matches it
+// accepts are replaced.
+type RedactionRule struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+	Valid       func(match string) bool
+}
+
+// Built-in redaction rules for RedactionRules.
+var (
+	// RedactCreditCards masks the card numbers of 13 to 19 digits, optionally grouped by spaces or dashes,
+	// passing the Luhn check so other long numbers such as order or trace IDs are kept.
+	RedactCreditCards = RedactionRule{regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), "[REDACTED CARD]", luhnValid}
+	// RedactAWSAccessKeys masks the AWS access key IDs.
+	RedactAWSAccessKeys = RedactionRule{regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`), "[REDACTED KEY]", nil}
+	// RedactBearerTokens masks the tokens of the bearer authorization headers.
+	RedactBearerTokens = RedactionRule{regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9\-._~+/]+=*`), "${1}[REDACTED]", nil}
+	// RedactSecretAssignments masks the values assigned to keys named like a password, secret or API key,
+	// as in password=hunter2 or "api_key": "abc".
+	RedactSecretAssignments = RedactionRule{regexp.MustCompile(`(?i)((?:password|passwd|secret|api[_-]?key|access[_-]?token)"?\s*[:=]\s*"?)[^\s",&]+`), "${1}[REDACTED]", nil}
+)
+
+// DefaultRedactionRules are the built-in redaction rules.
+var DefaultRedactionRules = []RedactionRule{RedactCreditCards, RedactAWSAccessKeys, RedactBearerTokens, RedactSecretAssignments}
+
+// redact applies the RedactionRules then the Redactor to the message, and counts the redacted messages.
+func (p *pusher) redact(message string) string {
+	redacted := message
+	for _, r := range p.RedactionRules {
+		redacted = r.apply(redacted)
+	}
+	if p.Redactor != nil {
+		redacted = p.Redactor(redacted)
+	}
+	if redacted != message {
+		atomic.AddInt64(&p.counters.redacted, 1)
+	}
+	return redacted
+}
+
+func (r RedactionRule) apply(message string) string {
+	if r.Valid == nil {
+		return r.Pattern.ReplaceAllString(message, r.Replacement)
+	}
+	var redacted []byte
+	last, replaced := 0, false
+	for _, m := range r.Pattern.FindAllStringSubmatchIndex(message, -1) {
+		if !r.Valid(message[m[0]:m[1]]) {
+			continue
+		}
+		redacted = append(redacted, message[last:m[0]]...)
+		redacted = r.Pattern.ExpandString(redacted, r.Replacement, message, m)
+		last, replaced = m[1], true
+	}
+	if !replaced {
+		return message
+	}
+	return string(append(redacted, message[last:]...))
+}
+
+// luhnValid returns whether the digits of s pass the Luhn check of the card numbers.
+func luhnValid(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n > 0 && sum%10 == 0
+}
@@ -0,0 +1,101 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+
+	"github.com/aws/amazon-cloudwatch-agent/logs"
+)
+
+const (
+	maxEventAge                     = 14 * 24 * time.Hour
+	maxEventAhead                   = 2 * time.Hour
+	defaultRetentionRefreshInterval = time.Hour
+)
+
+// validTime reports whether the event is in the time range accepted by CloudWatch Logs. With RetentionBound,
+// the oldest events accepted are the ones within the retention of the log group, once it is fetched.
+func (p *pusher) validTime(e logs.LogEvent) bool {
+	if !p.RetentionBound {
+		return hasValidTime(e)
+	}
+	p.retentionOnce.Do(func() { go p.refreshRetention() })
+	return hasValidTimeWithin(e, p.maxEventAge())
+}
+
+// maxEventAge returns the retention of the log group when it is known and shorter than 14 days,
+// or else 14 days.
+func (p *pusher) maxEventAge() time.Duration {
+	if days := atomic.LoadInt64(&p.counters.retentionDays); days > 0 && time.Duration(days)*24*time.Hour < maxEventAge {
+		return time.Duration(days) * 24 * time.Hour
+	}
+	return maxEventAge
+}
+
+// refreshRetention fetches the retention of the log group, then every RetentionRefreshInterval until the
+// pusher stops. The last retention fetched is kept when it cannot be refreshed. The retention stays unknown
+// when the service cannot describe the log groups.
+func (p *pusher) refreshRetention() {
+	describer, ok := p.Service.(logGroupDescriber)
+	if !ok {
+		return
+	}
+	interval := p.RetentionRefreshInterval
+	if interval <= 0 {
+		interval = defaultRetentionRefreshInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		p.fetchRetention(describer)
+		select {
+		case <-ticker.C:
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+func (p *pusher) fetchRetention(describer logGroupDescriber) {
+	group := p.target().Group
+	output, err := describer.DescribeLogGroups(&cloudwatchlogs.DescribeLogGroupsInput{
+		LogGroupNamePrefix: aws.String(group),
+	})
+	if err != nil {
+		p.Log.Debugf("Unable to describe log group %v for its retention: %v", group, err)
+		return
+	}
+	if output == nil {
+		return
+	}
+	for _, g := range output.LogGroups {
+		if aws.StringValue(g.LogGroupName) == group {
+			// Without retention, the events never expire
+			atomic.StoreInt64(&p.counters.retentionDays, aws.Int64Value(g.RetentionInDays))
+			return
+		}
+	}
+}
+
+func hasValidTime(e logs.LogEvent) bool {
+	return hasValidTimeWithin(e, maxEventAge)
+}
+
+func hasValidTimeWithin(e logs.LogEvent, maxAge time.Duration) bool {
+	//http://docs.aws.amazon.com/goto/SdkForGoV1/logs-2014-03-28/PutLogEvents
+	//* None of the log events in the batch can be more than 2 hours in the future.
+	//* None of the log events in the batch can be older than 14 days or the retention period of the log group.
+	if !e.Time().IsZero() {
+		dt := time.Since(e.Time())
+		if dt > maxAge || dt < -maxEventAhead {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"errors"
+	"time"
+)
+
+var errRetryQueueFull = errors.New("retry queue full")
+
+// sendOrEnqueue sends the batch right away if no failed batch is waiting, otherwise, or if the send
+// fails, the batch is queued so batches are still delivered in order.
+func (p *pusher) sendOrEnqueue(b *logBatch) {
+	if len(p.retryQueue) == 0 {
+		if !p.attemptSend(b) {
+			return
+		}
+		if !p.scheduleRetry(b) {
+			return
+		}
+	} else {
+		b.nextAttempt = time.Now()
+	}
+	p.enqueueRetry(b)
+}
+
+// scheduleRetry sets the next attempt time of a failed batch, or dead-letters it when giveUp does.
+func (p *pusher) scheduleRetry(b *logBatch) bool {
+	wait := retryWait(b.retryCount)
+	if p.giveUp(b, wait) {
+		return false
+	}
+	p.Log.Warnf("Retried %v time, going to retry in %v.", b.retryCount, wait)
+	b.retryCount++
+	b.nextAttempt = time.Now().Add(wait)
+	return true
+}
+
+func (p *pusher) enqueueRetry(b *logBatch) {
+	if len(p.retryQueue) >= p.RetryQueueSize {
+		oldest := p.retryQueue[0]
+		p.Log.Errorf("Retry queue of %v/%v is full, dropping the oldest failed batch of %v log events.", p.Group, p.Stream, len(oldest.events))
+		err := oldest.lastErr
+		if err == nil {
+			err = errRetryQueueFull
+		}
+		p.deadLetter(oldest.events, err)
+		p.popRetry()
+	}
+	p.retryQueue = append(p.retryQueue, b)
+	p.resetRetryTimer()
+}
+
+// processRetryQueue retries the queued batches which are due, in order, until one of them fails again.
+func (p *pusher) processRetryQueue() {
+	for len(p.retryQueue) > 0 {
+		b := p.retryQueue[0]
+		if time.Now().Before(b.nextAttempt) {
+			break
+		}
+		if p.attemptSend(b) && p.scheduleRetry(b) {
+			break
+		}
+		p.popRetry()
+	}
+	p.resetRetryTimer()
+}
+
+// drainRetryQueue retries the queued batches inline on stop.
+func (p *pusher) drainRetryQueue() {
+	for len(p.retryQueue) > 0 {
+		p.sendWithRetries(p.retryQueue[0])
+		p.popRetry()
+	}
+}
+
+func (p *pusher) popRetry() {
+	p.retryQueue[0] = nil
+	p.retryQueue = p.retryQueue[1:]
+}
+
+func (p *pusher) resetRetryTimer() {
+	p.retryTimer.Stop()
+	if len(p.retryQueue) > 0 {
+		p.retryTimer.Reset(time.Until(p.retryQueue[0].nextAttempt))
+	}
+}
+
+func newStoppedTimer() *time.Timer {
+	t := time.NewTimer(time.Hour)
+	t.Stop()
+	return t
+}
@@ -0,0 +1,74 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultSamplingAfter = 10 * time.Second
+	defaultSamplingRate  = 10
+)
+
+// keepSampled reports whether an event added under SamplingUnderPressure is kept. The sampling starts
+// once the events channel stayed at or above the high-water mark for SamplingAfter, and stops once the
+// channel is back under half the mark, so it does not flap around the mark.
+// Sampled in, 1 event out of SamplingRate is kept, in arrival order.
+func (p *pusher) keepSampled() bool {
+	c := p.counters
+	queued := len(p.eventsCh)
+	highWater := p.samplingHighWater()
+	rate := atomic.LoadInt64(&c.samplingRate)
+
+	switch {
+	case queued >= highWater && rate == 0:
+		now := time.Now().UnixNano()
+		if !atomic.CompareAndSwapInt64(&c.pressureSince, 0, now) {
+			since := atomic.LoadInt64(&c.pressureSince)
+			if time.Duration(now-since) >= p.samplingAfter() && atomic.CompareAndSwapInt64(&c.samplingRate, 0, int64(p.samplingRate())) {
+				p.Log.Warnf("Events queue for %v stayed above %v events for %v, keeping 1 in %v log events.", p.targetName(), highWater, p.samplingAfter(), p.samplingRate())
+			}
+		}
+	case queued < highWater && rate == 0:
+		atomic.StoreInt64(&c.pressureSince, 0)
+	case queued < highWater/2:
+		if atomic.CompareAndSwapInt64(&c.samplingRate, rate, 0) {
+			atomic.StoreInt64(&c.pressureSince, 0)
+			p.Log.Infof("Events queue for %v recovered, no longer sampling the log events.", p.targetName())
+		}
+	}
+
+	rate = atomic.LoadInt64(&c.samplingRate)
+	if rate == 0 {
+		return true
+	}
+	if atomic.AddInt64(&c.sampleSeq, 1)%rate == 0 {
+		return true
+	}
+	atomic.AddInt64(&c.sampledOut, 1)
+	return false
+}
+
+func (p *pusher) samplingHighWater() int {
+	if p.SamplingHighWater <= 0 || p.SamplingHighWater > cap(p.eventsCh) {
+		return cap(p.eventsCh) * 9 / 10
+	}
+	return p.SamplingHighWater
+}
+
+func (p *pusher) samplingAfter() time.Duration {
+	if p.SamplingAfter <= 0 {
+		return defaultSamplingAfter
+	}
+	return p.SamplingAfter
+}
+
+func (p *pusher) samplingRate() int {
+	if p.SamplingRate < 2 {
+		return defaultSamplingRate
+	}
+	return p.SamplingRate
+}
@@ -0,0 +1,33 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"strings"
+	"unicode"
+)
+
+// isSanitized reports whether the rune is a control character removed by SanitizeControlChars.
+// Tabs and line breaks are kept for the multiline messages.
+func isSanitized(r rune) bool {
+	return unicode.IsControl(r) && r != '\t' && r != '\n' && r != '\r'
+}
+
+// sanitize replaces the control characters of the message with ControlCharReplacement, or removes
+// them when it is 0, and counts the sanitized messages.
+func (p *pusher) sanitize(message string) string {
+	if strings.IndexFunc(message, isSanitized) < 0 {
+		return message
+	}
+	p.counters.addSanitized()
+	return strings.Map(func(r rune) rune {
+		if isSanitized(r) {
+			if p.ControlCharReplacement == 0 {
+				return -1
+			}
+			return p.ControlCharReplacement
+		}
+		return r
+	}, message)
+}
@@ -0,0 +1,42 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// SendAttemptInfo describes a PutLogEvents call of a pusher, for OnSendAttempt.
+type SendAttemptInfo struct {
+	Group, Stream string
+	// Attempt is the number of attempts made for the same events before, 0 for the first one.
+	Attempt int
+	Events  int
+	// Bytes is the size of the events counted against the request size limit.
+	Bytes int
+	// SequenceToken is the sequence token sent with the events, nil for none.
+	SequenceToken *string
+	Latency       time.Duration
+	// Err is the error of the call, nil when it succeeded.
+	Err error
+}
+
+// notifySendAttempt calls OnSendAttempt with the PutLogEvents call made for the input.
+func (p *pusher) notifySendAttempt(input *cloudwatchlogs.PutLogEventsInput, size, attempt int, err error, latency time.Duration) {
+	if p.OnSendAttempt == nil {
+		return
+	}
+	p.OnSendAttempt(SendAttemptInfo{
+		Group:         p.Group,
+		Stream:        p.Stream,
+		Attempt:       attempt,
+		Events:        len(input.LogEvents),
+		Bytes:         size,
+		SequenceToken: input.SequenceToken,
+		Latency:       latency,
+		Err:           err,
+	})
+}
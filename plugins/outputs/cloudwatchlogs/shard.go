@@ -0,0 +1,99 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	"github.com/aws/amazon-cloudwatch-agent/logs"
+)
+
+// sharded reports whether the events are routed to the shard streams instead of the stream of the pusher.
+func (p *pusher) sharded() bool {
+	return p.ShardKeyFn != nil && p.ShardCount > 1
+}
+
+// shardFor returns the shard pusher of the event, creating the shards on first use.
+func (p *pusher) shardFor(e logs.LogEvent) *pusher {
+	shards := p.createShards()
+	h := fnv.New32a()
+	h.Write([]byte(p.ShardKeyFn(e)))
+	return shards[h.Sum32()%uint32(len(shards))]
+}
+
+// createShards returns the pushers of the shard streams, created once with the options of the pusher.
+func (p *pusher) createShards() []*pusher {
+	p.shardsMu.Lock()
+	defer p.shardsMu.Unlock()
+	if p.shards == nil {
+		p.shards = make([]*pusher, p.ShardCount)
+		for i := range p.shards {
+			t := p.target()
+			t.Stream = fmt.Sprintf("%v-%d", t.Stream, i)
+			s := NewPusher(t, p.Service, p.FlushTimeout, p.RetryDuration, p.Log)
+			p.copyOptions(s)
+			p.shards[i] = s
+			// Stop could have missed the new pusher
+			if p.isStopped() {
+				s.Stop()
+			}
+		}
+	}
+	return p.shards
+}
+
+// shardPushers returns the pushers of the shard streams created so far, none before an event is sharded.
+func (p *pusher) shardPushers() []*pusher {
+	p.shardsMu.Lock()
+	defer p.shardsMu.Unlock()
+	return p.shards
+}
+
+// copyOptions sets the options of the pusher on a shard or stream pusher. The metadata of MetadataProvider
+// is shared rather than fetched again, and the callers mark the stream pushers as routed.
+func (p *pusher) copyOptions(s *pusher) {
+	s.pusherOptions = p.pusherOptions
+	if p.MetadataProvider != nil {
+		s.providerMetadata = p.metadataSource()
+	}
+}
+
+// addShardStats adds the counters of the shard pushers to the stats of the pusher.
+func (p *pusher) addShardStats(stats *PusherStats) {
+	if !p.sharded() {
+		return
+	}
+	for _, s := range p.shardPushers() {
+		mergeStats(stats, s.Stats())
+	}
+}
+
+// mergeStats adds the counters of a child pusher to the stats.
+func mergeStats(stats *PusherStats, ss PusherStats) {
+	stats.EventsSent += ss.EventsSent
+	stats.EventsDropped += ss.EventsDropped
+	stats.BytesSent += ss.BytesSent
+	stats.Batches += ss.Batches
+	stats.Errors += ss.Errors
+	stats.BatchesAbandoned += ss.BatchesAbandoned
+	stats.SampledOut += ss.SampledOut
+	stats.Duplicates += ss.Duplicates
+	stats.Sanitized += ss.Sanitized
+	stats.StartupSyncSends += ss.StartupSyncSends
+	stats.ConcurrentWriters += ss.ConcurrentWriters
+	stats.MixedTimestampUnits += ss.MixedTimestampUnits
+	stats.EventsArchived += ss.EventsArchived
+	stats.TokenedSends += ss.TokenedSends
+	stats.TokenlessSends += ss.TokenlessSends
+	stats.Redacted += ss.Redacted
+	stats.DedupEntries += ss.DedupEntries
+	stats.RoutedStreams += ss.RoutedStreams
+	if ss.SamplingRate > stats.SamplingRate {
+		stats.SamplingRate = ss.SamplingRate
+	}
+	if ss.LastLatency > stats.LastLatency {
+		stats.LastLatency = ss.LastLatency
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"sort"
+	"time"
+)
+
+// snapshotPreviewLength is the maximum length in bytes of the message previews of Snapshot.
+const snapshotPreviewLength = 256
+
+// LogEventView is a read-only view of an event of the current batch, for debugging.
+type LogEventView struct {
+	// Preview is the message, cut to its first 256 bytes.
+	Preview string
+	// Truncated is whether the preview is shorter than the message.
+	Truncated bool
+	Timestamp time.Time
+	// Size is the length of the message in bytes.
+	Size int
+}
+
+// Snapshot returns a copy of the events of the current batch, in the order they will be sent, to inspect
+// a stuck stream. It is taken from the start loop, so it may wait for a send in progress. It is nil once
+// the pusher is stopped.
+func (p *pusher) Snapshot() []LogEventView {
+	var views []LogEventView
+	err := p.controlWait(func() {
+		views = make([]LogEventView, 0, len(p.events))
+		for _, e := range p.events {
+			views = append(views, newLogEventView(*e.Message, *e.Timestamp))
+		}
+		// The batch is only sorted when sent, the events of equal timestamps keep their arrival order
+		if p.needSort {
+			sort.SliceStable(views, func(i, j int) bool { return views[i].Timestamp.Before(views[j].Timestamp) })
+		}
+	})
+	if err != nil {
+		return nil
+	}
+	return views
+}
+
+func newLogEventView(msg string, timestamp int64) LogEventView {
+	v := LogEventView{
+		Preview:   msg,
+		Timestamp: time.Unix(0, timestamp*int64(time.Millisecond)),
+		Size:      len(msg),
+	}
+	if len(msg) > snapshotPreviewLength {
+		v.Preview = truncateUTF8(msg, snapshotPreviewLength)
+		v.Truncated = true
+	}
+	return v
+}
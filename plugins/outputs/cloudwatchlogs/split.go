@@ -0,0 +1,97 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/amazon-cloudwatch-agent/logs"
+)
+
+// splitLogEvent is a chunk of an oversized log event.
+type splitLogEvent struct {
+	msg    string
+	t      time.Time
+	done   func()
+	source logs.LogEvent // the oversized event, for its PartitionFn key
+}
+
+func (e *splitLogEvent) Message() string { return e.msg }
+func (e *splitLogEvent) Time() time.Time { return e.t }
+func (e *splitLogEvent) Done()           { e.done() }
+
+func (e *splitLogEvent) Deadline() (time.Time, bool) { return eventDeadline(e.source) }
+
+// addSplit adds an oversized log event as its consecutive chunks from splitMessage, sharing its timestamp. The event is done once all its chunks are delivered. The chunks spanning several
+// batches are sent in order: the batch starting with the last chunks is sent before an event added
+// later is sorted among them.
+func (p *pusher) addSplit(e logs.LogEvent, chunks []string) {
+	remaining := len(chunks)
+	done := func() {
+		remaining--
+		if remaining == 0 {
+			e.Done()
+		}
+	}
+	first := p.nextSeq
+	for _, c := range chunks {
+		p.addToBatch(&splitLogEvent{msg: c, t: e.Time(), done: done, source: e})
+	}
+	if len(p.seqs) > 0 && p.seqs[0] > first {
+		p.splitTail = true
+		p.splitTailTimestamp = *p.events[0].Timestamp
+	}
+}
+
+// splitLimit is the size of the chunks of a split message, leaving room for the sequence number and
+// metadata injected in each chunk unless the size limit is too small for them.
+func (p *pusher) splitLimit() int {
+	limit := p.msgSizeLimit()
+	if p.InjectSequenceNumber && limit > 2*maxSeqPrefixSize {
+		limit -= maxSeqPrefixSize
+	}
+	if len(p.EnrichFields) > 0 {
+		if n := len(p.enrich("")); limit > 2*n {
+			limit -= n
+		}
+	}
+	return limit
+}
+
+// splitMessage cuts the message at rune boundaries into chunks of at most limit bytes, prefixed by [i/n]
+// markers. It returns nil when the limit cannot hold a marker and a rune.
+func splitMessage(message string, limit int) []string {
+	// The markers get longer with the number of chunks, so retry with longer markers until they fit
+	for n := 1; ; n *= 10 {
+		size := limit - len(fmt.Sprintf("[%d/%d] ", n, n))
+		if size < utf8.UTFMax {
+			return nil
+		}
+		var parts []string
+		for rest := message; len(rest) > 0; {
+			cut := len(rest)
+			if cut > size {
+				cut = size
+				for cut > 0 && !utf8.RuneStart(rest[cut]) {
+					cut--
+				}
+				if cut == 0 {
+					// The limit is too small for the rune, keep it whole
+					_, cut = utf8.DecodeRuneInString(rest)
+				}
+			}
+			parts = append(parts, rest[:cut])
+			rest = rest[cut:]
+		}
+		if len(parts) < n*10 {
+			chunks := make([]string, len(parts))
+			for i, part := range parts {
+				chunks[i] = fmt.Sprintf("[%d/%d] ", i+1, len(parts)) + part
+			}
+			return chunks
+		}
+	}
+}
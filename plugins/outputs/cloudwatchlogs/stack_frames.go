@@ -0,0 +1,49 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// collapseFrames collapses the runs of consecutive lines of a multiline message with the same key into
+// their first line followed by " (× N)". The lines are compared by FrameKey, or by their text without
+// the surrounding spaces when it is nil. The lines with an empty key are never collapsed.
+func (p *pusher) collapseFrames(message string) string {
+	if !strings.Contains(message, "\n") {
+		return message
+	}
+	key := p.FrameKey
+	if key == nil {
+		key = strings.TrimSpace
+	}
+
+	lines := strings.Split(message, "\n")
+	collapsed := make([]string, 0, len(lines))
+	for i := 0; i < len(lines); {
+		k := key(lines[i])
+		j := i + 1
+		if k != "" {
+			for j < len(lines) && key(lines[j]) == k {
+				j++
+			}
+		}
+		line := lines[i]
+		if n := j - i; n > 1 {
+			// The count goes before the carriage return of a CRLF line
+			cr := strings.HasSuffix(line, "\r")
+			line = fmt.Sprintf("%v (× %d)", strings.TrimSuffix(line, "\r"), n)
+			if cr {
+				line += "\r"
+			}
+		}
+		collapsed = append(collapsed, line)
+		i = j
+	}
+	if len(collapsed) == len(lines) {
+		return message
+	}
+	return strings.Join(collapsed, "\n")
+}
@@ -0,0 +1,145 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// StdoutCloudWatchLogsService is a CloudWatchLogsService that prints the log events it would send to
+// CloudWatch Logs instead of calling the API, for local debugging without AWS credentials.
+// It keeps the groups, streams and sequence tokens in memory and fails the calls the same way
+// CloudWatch Logs does, so the pusher goes through its usual create and token handling.
+type StdoutCloudWatchLogsService struct {
+	mu      sync.Mutex
+	w       io.Writer
+	groups  map[string]struct{}
+	streams map[Target]*string // the next expected sequence token of each stream, nil for a new stream
+	tokens  int64
+}
+
+var _ CloudWatchLogsService = (*StdoutCloudWatchLogsService)(nil)
+
+// NewStdoutCloudWatchLogsService returns a service writing the log events to w, or to stdout if w is nil.
+func NewStdoutCloudWatchLogsService(w io.Writer) *StdoutCloudWatchLogsService {
+	if w == nil {
+		w = os.Stdout
+	}
+	return &StdoutCloudWatchLogsService{
+		w:       w,
+		groups:  make(map[string]struct{}),
+		streams: make(map[Target]*string),
+	}
+}
+
+func (s *StdoutCloudWatchLogsService) PutLogEvents(input *cloudwatchlogs.PutLogEventsInput) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := Target{Group: aws.StringValue(input.LogGroupName), Stream: aws.StringValue(input.LogStreamName)}
+	expected, ok := s.streams[t]
+	if !ok {
+		return nil, &cloudwatchlogs.ResourceNotFoundException{
+			Message_: aws.String(fmt.Sprintf("The specified log stream %v/%v does not exist.", t.Group, t.Stream)),
+		}
+	}
+	if aws.StringValue(expected) != aws.StringValue(input.SequenceToken) {
+		return nil, &cloudwatchlogs.InvalidSequenceTokenException{
+			Message_:              aws.String(fmt.Sprintf("The given sequenceToken is invalid. The next expected sequenceToken is: %v", aws.StringValue(expected))),
+			ExpectedSequenceToken: expected,
+		}
+	}
+
+	for _, e := range input.LogEvents {
+		ts := time.Unix(0, aws.Int64Value(e.Timestamp)*int64(time.Millisecond)).UTC()
+		if _, err := fmt.Fprintf(s.w, "%v %v/%v %v\n", ts.Format(time.RFC3339Nano), t.Group, t.Stream, aws.StringValue(e.Message)); err != nil {
+			return nil, err
+		}
+	}
+
+	s.tokens++
+	next := aws.String(strconv.FormatInt(s.tokens, 10))
+	s.streams[t] = next
+	return &cloudwatchlogs.PutLogEventsOutput{NextSequenceToken: next}, nil
+}
+
+func (s *StdoutCloudWatchLogsService) CreateLogStream(input *cloudwatchlogs.CreateLogStreamInput) (*cloudwatchlogs.CreateLogStreamOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := Target{Group: aws.StringValue(input.LogGroupName), Stream: aws.StringValue(input.LogStreamName)}
+	if _, ok := s.groups[t.Group]; !ok {
+		return nil, &cloudwatchlogs.ResourceNotFoundException{
+			Message_: aws.String(fmt.Sprintf("The specified log group %v does not exist.", t.Group)),
+		}
+	}
+	if _, ok := s.streams[t]; ok {
+		return nil, &cloudwatchlogs.ResourceAlreadyExistsException{
+			Message_: aws.String(fmt.Sprintf("The specified log stream %v/%v already exists.", t.Group, t.Stream)),
+		}
+	}
+	s.streams[t] = nil
+	return &cloudwatchlogs.CreateLogStreamOutput{}, nil
+}
+
+func (s *StdoutCloudWatchLogsService) CreateLogGroup(input *cloudwatchlogs.CreateLogGroupInput) (*cloudwatchlogs.CreateLogGroupOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	group := aws.StringValue(input.LogGroupName)
+	if _, ok := s.groups[group]; ok {
+		return nil, &cloudwatchlogs.ResourceAlreadyExistsException{
+			Message_: aws.String(fmt.Sprintf("The specified log group %v already exists.", group)),
+		}
+	}
+	s.groups[group] = struct{}{}
+	return &cloudwatchlogs.CreateLogGroupOutput{}, nil
+}
+
+func (s *StdoutCloudWatchLogsService) DescribeLogGroups(input *cloudwatchlogs.DescribeLogGroupsInput) (*cloudwatchlogs.DescribeLogGroupsOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	output := &cloudwatchlogs.DescribeLogGroupsOutput{}
+	for group := range s.groups {
+		if input.LogGroupNamePrefix != nil && !strings.HasPrefix(group, *input.LogGroupNamePrefix) {
+			continue
+		}
+		output.LogGroups = append(output.LogGroups, &cloudwatchlogs.LogGroup{LogGroupName: aws.String(group)})
+	}
+	return output, nil
+}
+
+func (s *StdoutCloudWatchLogsService) DescribeLogStreams(input *cloudwatchlogs.DescribeLogStreamsInput) (*cloudwatchlogs.DescribeLogStreamsOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	group := aws.StringValue(input.LogGroupName)
+	if _, ok := s.groups[group]; !ok {
+		return nil, &cloudwatchlogs.ResourceNotFoundException{
+			Message_: aws.String(fmt.Sprintf("The specified log group %v does not exist.", group)),
+		}
+	}
+
+	output := &cloudwatchlogs.DescribeLogStreamsOutput{}
+	for t, token := range s.streams {
+		if t.Group != group || (input.LogStreamNamePrefix != nil && !strings.HasPrefix(t.Stream, *input.LogStreamNamePrefix)) {
+			continue
+		}
+		output.LogStreams = append(output.LogStreams, &cloudwatchlogs.LogStream{
+			LogStreamName:       aws.String(t.Stream),
+			UploadSequenceToken: token,
+		})
+	}
+	return output, nil
+}
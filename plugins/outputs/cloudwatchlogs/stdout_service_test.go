@@ -0,0 +1,65 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/influxdata/telegraf/models"
+)
+
+func TestStdoutServiceSequenceToken(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewStdoutCloudWatchLogsService(&buf)
+	input := &cloudwatchlogs.PutLogEventsInput{
+		LogGroupName:  aws.String("G"),
+		LogStreamName: aws.String("S"),
+		LogEvents:     []*cloudwatchlogs.InputLogEvent{{Message: aws.String("msg"), Timestamp: aws.Int64(0)}},
+	}
+
+	if _, err := s.PutLogEvents(input); err == nil {
+		t.Errorf("Expecting ResourceNotFoundException before the stream is created")
+	}
+	s.CreateLogGroup(&cloudwatchlogs.CreateLogGroupInput{LogGroupName: aws.String("G")})
+	s.CreateLogStream(&cloudwatchlogs.CreateLogStreamInput{LogGroupName: aws.String("G"), LogStreamName: aws.String("S")})
+
+	output, err := s.PutLogEvents(input)
+	if err != nil || output.NextSequenceToken == nil {
+		t.Fatalf("Expecting a sequence token from the first send, got %v, %v", output, err)
+	}
+	_, err = s.PutLogEvents(input)
+	if e, ok := err.(*cloudwatchlogs.InvalidSequenceTokenException); !ok || *e.ExpectedSequenceToken != *output.NextSequenceToken {
+		t.Errorf("Expecting InvalidSequenceTokenException with the expected token, got %v", err)
+	}
+	input.SequenceToken = output.NextSequenceToken
+	if _, err := s.PutLogEvents(input); err != nil {
+		t.Errorf("Send with the expected sequence token should succeed, got %v", err)
+	}
+
+	if got, want := buf.String(), "1970-01-01T00:00:00Z G/S msg\n"; got != want+want {
+		t.Errorf("Unexpected output %q", got)
+	}
+}
+
+func TestStdoutServiceWithPusher(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewStdoutCloudWatchLogsService(&buf)
+	p := NewPusher(Target{Group: "G", Stream: "S"}, s, 1*time.Hour, maxRetryTimeout, models.NewLogger("cloudwatchlogs", "test", ""))
+
+	for i := 0; i < 2; i++ {
+		p.AddEvent(evtMock{"msg", time.Now(), nil})
+		time.Sleep(10 * time.Millisecond)
+		p.send()
+	}
+	p.Stop()
+
+	if n := strings.Count(buf.String(), "G/S msg\n"); n != 2 {
+		t.Errorf("Expecting 2 events to be printed, but got %q", buf.String())
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"time"
+
+	"github.com/aws/amazon-cloudwatch-agent/logs"
+)
+
+const (
+	defaultMaxStreams        = 100
+	defaultStreamIdleTimeout = 5 * time.Minute
+)
+
+// StreamLogEvent is a log event tagged with the log stream it is sent to, in the log group of the pusher.
+// The events of the stream of the pusher or without a stream name are batched by the pusher itself.
+type StreamLogEvent interface {
+	logs.LogEvent
+	StreamName() string
+}
+
+// streamBatch is the pusher batching the events of a log group and stream other than the ones of the
+// pusher, from RouteFn or StreamLogEvent.
+type streamBatch struct {
+	p        *pusher
+	lastUsed time.Time
+	adding   int // events being added to the pusher, which is not evicted meanwhile
+}
+
+// eventTarget returns the log group and stream of an event, as routed by RouteFn or tagged by
+// StreamLogEvent. The empty group or stream of a route is the one of the pusher.
+func (p *pusher) eventTarget(e logs.LogEvent) Target {
+	t := p.target()
+	if p.routed {
+		return t
+	}
+	if p.RouteFn != nil {
+		group, stream := p.RouteFn(e)
+		if group != "" {
+			t.Group = group
+		}
+		if stream != "" {
+			t.Stream = stream
+		}
+	} else if se, ok := e.(StreamLogEvent); ok && se.StreamName() != "" {
+		t.Stream = se.StreamName()
+	}
+	return t
+}
+
+// streamPusher returns the batch of the log group and stream, with a pusher created with the options of
+// the pusher, or nil when their names are invalid. The batch is not evicted until it is released with
+// releaseStream. The pushers idle for StreamIdleTimeout are evicted, as well as the least recently used
+// one when there are already MaxStreams of them. The pusher of a stream being evicted is only created
+// again once the evicted one sent its batch, so their requests do not interleave.
+func (p *pusher) streamPusher(t Target) *streamBatch {
+	p.streamsMu.Lock()
+	defer p.streamsMu.Unlock()
+
+	now := time.Now()
+	idle := p.StreamIdleTimeout
+	if idle <= 0 {
+		idle = defaultStreamIdleTimeout
+	}
+	if now.Sub(p.streamsChecked) >= idle/10 {
+		p.streamsChecked = now
+		for t, b := range p.streams {
+			if b.adding == 0 && now.Sub(b.lastUsed) >= idle {
+				p.evictStream(t)
+			}
+		}
+	}
+
+	for {
+		if b, ok := p.streams[t]; ok {
+			b.lastUsed = now
+			b.adding++
+			return b
+		}
+		closing, ok := p.streamsClosing[t]
+		if !ok {
+			break
+		}
+		p.streamsMu.Unlock()
+		<-closing
+		p.streamsMu.Lock()
+	}
+
+	if err := validateTarget(t); err != nil {
+		return nil
+	}
+	max := p.MaxStreams
+	if max <= 0 {
+		max = defaultMaxStreams
+	}
+	// The batches events are being added to are kept, even if it makes more than MaxStreams for a while
+	for len(p.streams) >= max {
+		var lru *streamBatch
+		for _, b := range p.streams {
+			if b.adding == 0 && (lru == nil || b.lastUsed.Before(lru.lastUsed)) {
+				lru = b
+			}
+		}
+		if lru == nil {
+			break
+		}
+		p.evictStream(lru.p.target())
+	}
+
+	if p.streams == nil {
+		p.streams = make(map[Target]*streamBatch)
+	}
+	flushTimeout := p.FlushTimeout
+	if p.StreamFlushTimeout != nil {
+		if d := p.StreamFlushTimeout(t); d > 0 {
+			flushTimeout = d
+		}
+	}
+	s := NewPusher(t, p.Service, flushTimeout, p.RetryDuration, p.Log)
+	p.copyOptions(s)
+	s.routed = true
+	b := &streamBatch{p: s, lastUsed: now, adding: 1}
+	p.streams[t] = b
+	// Stop could have missed the new pusher
+	if p.isStopped() {
+		s.Stop()
+	}
+	return b
+}
+
+// releaseStream lets the batch be evicted again once the event is added to it.
+func (p *pusher) releaseStream(b *streamBatch) {
+	p.streamsMu.Lock()
+	b.adding--
+	p.streamsMu.Unlock()
+}
+
+// evictStream closes the pusher of the log group and stream in the background, its batch is still sent.
+// It must be called with streamsMu held.
+func (p *pusher) evictStream(t Target) {
+	s := p.streams[t].p
+	delete(p.streams, t)
+	p.Log.Debugf("Evicting the batch of log stream %v/%v", t.Group, t.Stream)
+	if p.streamsClosing == nil {
+		p.streamsClosing = make(map[Target]chan struct{})
+	}
+	closed := make(chan struct{})
+	p.streamsClosing[t] = closed
+	p.streamsWg.Add(1)
+	go func() {
+		defer p.streamsWg.Done()
+		stats, _ := s.Close()
+		// The occupancy of the evicted pusher is gone with it
+		stats.DedupEntries, stats.RoutedStreams = 0, 0
+		p.streamsMu.Lock()
+		mergeStats(&p.evictedStreamStats, stats)
+		delete(p.streamsClosing, t)
+		p.streamsMu.Unlock()
+		close(closed)
+	}()
+}
+
+// addStreamEvent adds the event to the batch of its log group and stream, and drops it when their names
+// are invalid.
+func (p *pusher) addStreamEvent(e logs.LogEvent, t Target, nonBlocking bool) {
+	b := p.streamPusher(t)
+	if b == nil {
+		p.Log.Errorf("Invalid log group or stream name %v/%v for the log entry. Discard the log entry.", t.Group, t.Stream)
+		p.counters.addDropped(1)
+		return
+	}
+	defer p.releaseStream(b)
+	if nonBlocking {
+		b.p.AddEventNonBlocking(e)
+	} else {
+		b.p.AddEvent(e)
+	}
+}
+
+// streamPushers returns the pushers of the log groups and streams routed or tagged by the events.
+func (p *pusher) streamPushers() []*pusher {
+	p.streamsMu.Lock()
+	defer p.streamsMu.Unlock()
+	pushers := make([]*pusher, 0, len(p.streams))
+	for _, b := range p.streams {
+		pushers = append(pushers, b.p)
+	}
+	return pushers
+}
+
+// closeStreams closes the stream pushers, including the ones being evicted, and returns the first error.
+func (p *pusher) closeStreams() error {
+	var firstErr error
+	for _, s := range p.streamPushers() {
+		if _, err := s.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	p.streamsWg.Wait()
+	return firstErr
+}
+
+// addStreamStats adds the counters of the stream pushers, evicted ones included, to the stats of the pusher.
+func (p *pusher) addStreamStats(stats *PusherStats) {
+	pushers := p.streamPushers()
+	for _, s := range pushers {
+		mergeStats(stats, s.Stats())
+	}
+	stats.RoutedStreams += int64(len(pushers))
+	p.streamsMu.Lock()
+	mergeStats(stats, p.evictedStreamStats)
+	p.streamsMu.Unlock()
+}
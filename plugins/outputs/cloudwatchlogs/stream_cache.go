@@ -0,0 +1,111 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"container/list"
+	"reflect"
+	"sync"
+)
+
+const defaultStreamCacheSize = 10000
+
+// knownStreams records the log streams known to exist, shared by all the pushers of the process
+// so a stream created or described once does not need to be created again.
+var knownStreams = newStreamCache()
+
+// streamKey identifies a log stream in knownStreams. The same group and stream names are distinct streams
+// in each region and endpoint, and each role may belong to another account.
+type streamKey struct {
+	Target
+	region, endpoint string
+	// service scopes the streams of a service which is not an SDK client, whose endpoint is unknown
+	service CloudWatchLogsService
+}
+
+// streamKey returns the key of the log stream of the pusher in knownStreams.
+func (p *pusher) streamKey() streamKey {
+	k := streamKey{Target: p.Target}
+	k.region, k.endpoint = p.Endpoint()
+	if k.endpoint == "" {
+		if t := reflect.TypeOf(p.Service); t != nil && t.Comparable() {
+			k.service = p.Service
+		}
+	}
+	return k
+}
+
+// streamCache is a bounded LRU of the log streams known to exist. A stream evicted is described or created
+// again when sent to, so the bound only costs API calls to the processes sending to many streams.
+type streamCache struct {
+	mu      sync.Mutex
+	size    int
+	streams map[streamKey]*list.Element
+	lru     *list.List // most recently used first
+}
+
+func newStreamCache() *streamCache {
+	return &streamCache{size: defaultStreamCacheSize, streams: make(map[streamKey]*list.Element), lru: list.New()}
+}
+
+// SetStreamCacheSize bounds the number of log streams known to exist remembered by the process, 10000 when
+// it is 0. The least recently used ones are forgotten first.
+func SetStreamCacheSize(n int) {
+	knownStreams.setSize(n)
+}
+
+func (c *streamCache) setSize(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n <= 0 {
+		n = defaultStreamCacheSize
+	}
+	c.size = n
+	c.evict()
+}
+
+func (c *streamCache) has(k streamKey) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.streams[k]
+	if ok {
+		c.lru.MoveToFront(el)
+	}
+	return ok
+}
+
+func (c *streamCache) add(k streamKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.streams[k]; ok {
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.streams[k] = c.lru.PushFront(k)
+	c.evict()
+}
+
+func (c *streamCache) remove(k streamKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.streams[k]; ok {
+		c.lru.Remove(el)
+		delete(c.streams, k)
+	}
+}
+
+func (c *streamCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// evict forgets the least recently used streams over the size. It must be called with mu held.
+func (c *streamCache) evict() {
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.streams, oldest.Value.(streamKey))
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/amazon-cloudwatch-agent/logs"
+)
+
+// TimestampSource is the time of a log event used as its CloudWatch Logs timestamp.
+type TimestampSource int
+
+const (
+	// EventTime is the time of the event, as returned by its Time method.
+	EventTime TimestampSource = iota
+	// IngestTime is the time the agent received the event: the IngestTime of an IngestTimeLogEvent,
+	// or the time the pusher converts it otherwise.
+	IngestTime
+)
+
+// The fields of the time embedded in the message by EmbedOtherTime.
+const (
+	eventTimeField  = "event_time"
+	ingestTimeField = "ingest_time"
+)
+
+func (s TimestampSource) String() string {
+	switch s {
+	case EventTime:
+		return "EventTime"
+	case IngestTime:
+		return "IngestTime"
+	}
+	return fmt.Sprintf("TimestampSource(%d)", int(s))
+}
+
+// IngestTimeLogEvent is implemented by log events which know when the agent received them, such as
+// the events of a replayed file whose time is the original event time.
+type IngestTimeLogEvent interface {
+	logs.LogEvent
+	IngestTime() time.Time
+}
+
+func ingestTime(e logs.LogEvent) time.Time {
+	if ie, ok := e.(IngestTimeLogEvent); ok && !ie.IngestTime().IsZero() {
+		return ie.IngestTime()
+	}
+	return time.Now()
+}
+
+// embedOtherTime adds the time of the event not used as its timestamp to the message, as an
+// event_time or ingest_time field in RFC 3339 format. An event without time has no event_time.
+func (p *pusher) embedOtherTime(message string, eventTime, ingest time.Time) string {
+	f := metadataField{ingestTimeField, ingest.UTC().Format(time.RFC3339Nano)}
+	if p.TimestampSource == IngestTime {
+		if eventTime.IsZero() {
+			return message
+		}
+		f = metadataField{eventTimeField, eventTime.UTC().Format(time.RFC3339Nano)}
+	}
+	return addFields(message, []metadataField{f})
+}
@@ -0,0 +1,137 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/amazon-cloudwatch-agent/logs"
+)
+
+const (
+	// timestampUnitSamples is the number of recent event times the median is taken over.
+	timestampUnitSamples = 31
+	// timestampUnitTolerance is how far from the median an event time may be and still be plausible,
+	// or once scaled by 1000 to be taken as in the wrong unit.
+	timestampUnitTolerance = int64(maxEventAge / time.Millisecond)
+	// timestampUnitWarnInterval is the minimum interval between the warnings about mixed units.
+	timestampUnitWarnInterval = time.Minute
+)
+
+// timestampUnits tracks the median of the recent plausible event times of a stream, in milliseconds.
+// It is shared by the goroutines adding events.
+type timestampUnits struct {
+	mu       sync.Mutex
+	samples  []int64
+	next     int
+	median   int64 // 0 until the first samples are taken
+	lastWarn time.Time
+}
+
+// check returns the event time t in milliseconds corrected by 1000 and true when t is implausible against
+// the median but plausible once scaled, and records t as a sample when it is plausible.
+func (u *timestampUnits) check(t int64) (int64, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	ref := u.median
+	if ref == 0 {
+		ref = time.Now().UnixNano() / int64(time.Millisecond)
+	}
+	switch {
+	case abs64(t-ref) <= timestampUnitTolerance:
+		u.addSample(t)
+		return t, false
+	case abs64(t-ref/1000) <= timestampUnitTolerance/1000:
+		// In seconds taken as milliseconds, the event is in January 1970
+		return t * 1000, true
+	case abs64(t/1000-ref) <= timestampUnitTolerance:
+		// In milliseconds taken as seconds, the event is around the year 52000
+		return t / 1000, true
+	}
+	// Too far off for a unit mix-up, left to the time range check
+	return t, false
+}
+
+func (u *timestampUnits) addSample(t int64) {
+	if len(u.samples) < timestampUnitSamples {
+		u.samples = append(u.samples, t)
+	} else {
+		u.samples[u.next] = t
+	}
+	u.next = (u.next + 1) % timestampUnitSamples
+	// The median is only recomputed once every timestampUnitSamples samples, sorting a copy
+	if u.next == 0 || u.median == 0 {
+		sorted := append([]int64(nil), u.samples...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		u.median = sorted[len(sorted)/2]
+	}
+}
+
+// warnDue reports whether a warning about mixed units is due, at most once per timestampUnitWarnInterval.
+func (u *timestampUnits) warnDue() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if time.Since(u.lastWarn) < timestampUnitWarnInterval {
+		return false
+	}
+	u.lastWarn = time.Now()
+	return true
+}
+
+func abs64(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// retimedLogEvent is a log event whose time was corrected.
+type retimedLogEvent struct {
+	wrappedLogEvent
+	t time.Time
+}
+
+func (e *retimedLogEvent) Time() time.Time { return e.t }
+
+// retimedPartialLogEvent keeps the partial events recognizable once retimed.
+type retimedPartialLogEvent struct {
+	wrappedPartialLogEvent
+	t time.Time
+}
+
+func (e *retimedPartialLogEvent) Time() time.Time { return e.t }
+
+// checkTimestampUnits detects an event time 1000 times too far from the recent event times of the stream,
+// as from a source mixing timestamps in seconds and milliseconds. The event is counted in Stats, and
+// returned with its time corrected with CorrectTimestampUnits, or else as is.
+func (p *pusher) checkTimestampUnits(e logs.LogEvent) logs.LogEvent {
+	et := e.Time()
+	if et.IsZero() {
+		return e
+	}
+	t := et.Unix()*1000 + int64(et.Nanosecond())/int64(time.Millisecond)
+	corrected, mixed := p.tsUnits.check(t)
+	if !mixed {
+		return e
+	}
+	atomic.AddInt64(&p.counters.mixedTimestampUnits, 1)
+	ct := time.Unix(0, corrected*int64(time.Millisecond))
+	if p.tsUnits.warnDue() {
+		if p.CorrectTimestampUnits {
+			p.Log.Warnf("Log event for %v with timestamp (%v) is likely in the wrong unit, correcting it to (%v). The source may mix seconds and milliseconds.", p.targetName(), et, ct)
+		} else {
+			p.Log.Warnf("Log event for %v with timestamp (%v) is likely in the wrong unit, (%v) was likely meant. The source may mix seconds and milliseconds.", p.targetName(), et, ct)
+		}
+	}
+	if !p.CorrectTimestampUnits {
+		return e
+	}
+	if pe, ok := e.(PartialLogEvent); ok {
+		return &retimedPartialLogEvent{wrapPartial(pe), ct}
+	}
+	return &retimedLogEvent{wrappedLogEvent{e}, ct}
+}
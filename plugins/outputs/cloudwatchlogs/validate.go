@@ -0,0 +1,183 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// validationProbeAge is the age of the probe event, older than CloudWatch Logs accepts, so the probe
+// is rejected as too old and never stored.
+const validationProbeAge = 15 * 24 * time.Hour
+
+// describeContextService is implemented by the services able to bound the describe requests with a
+// context, such as the SDK client.
+type describeContextService interface {
+	DescribeLogGroupsWithContext(ctx aws.Context, input *cloudwatchlogs.DescribeLogGroupsInput, opts ...request.Option) (*cloudwatchlogs.DescribeLogGroupsOutput, error)
+	DescribeLogStreamsWithContext(ctx aws.Context, input *cloudwatchlogs.DescribeLogStreamsInput, opts ...request.Option) (*cloudwatchlogs.DescribeLogStreamsOutput, error)
+}
+
+// Validate checks without sending any log data that the log group and stream of the pusher exist and
+// that log events can be put to them, for a pre-flight check of a configuration. The log group and
+// stream are only checked when the service can describe them. The permission probe is a PutLogEvents
+// call of a single event too old to be stored, made from the send loop with the sequence token of the
+// pusher, which it keeps up to date. The requests are cancelled when ctx is done, if the service supports
+// contexts, such as the SDK client.
+func (p *pusher) Validate(ctx context.Context) error {
+	t := p.target()
+	if err := p.validate(ctx, t); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("validation of %v/%v not completed: %w", t.Group, t.Stream, ctx.Err())
+		}
+		return err
+	}
+
+	result := make(chan error, 1)
+	probe := &controlEvent{func() { result <- p.probePut(ctx, t) }}
+	select {
+	case p.eventsCh <- probe:
+	case <-p.stop:
+		return errPusherStopped
+	case <-ctx.Done():
+		return fmt.Errorf("validation of %v/%v not completed: %w", t.Group, t.Stream, ctx.Err())
+	}
+	select {
+	case err := <-result:
+		return err
+	case <-p.stop:
+		return errPusherStopped
+	case <-ctx.Done():
+		return fmt.Errorf("validation of %v/%v not completed: %w", t.Group, t.Stream, ctx.Err())
+	}
+}
+
+func (p *pusher) validate(ctx context.Context, t Target) error {
+	if err := validateTarget(t); err != nil {
+		return err
+	}
+	if p.Entity != nil {
+		if err := p.Entity.Validate(); err != nil {
+			return fmt.Errorf("invalid entity for %v/%v: %w", t.Group, t.Stream, err)
+		}
+	}
+
+	cs, withContext := p.Service.(describeContextService)
+	if describer, ok := p.Service.(logGroupDescriber); ok {
+		input := &cloudwatchlogs.DescribeLogGroupsInput{LogGroupNamePrefix: aws.String(t.Group)}
+		var groups *cloudwatchlogs.DescribeLogGroupsOutput
+		var err error
+		if withContext {
+			groups, err = cs.DescribeLogGroupsWithContext(ctx, input)
+		} else {
+			groups, err = describer.DescribeLogGroups(input)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to describe log group %v: %w", t.Group, err)
+		}
+		if !hasLogGroup(groups, t.Group) {
+			return fmt.Errorf("log group %v does not exist", t.Group)
+		}
+	}
+
+	if describer, ok := p.Service.(logStreamDescriber); ok {
+		input := &cloudwatchlogs.DescribeLogStreamsInput{
+			LogGroupName:        aws.String(t.Group),
+			LogStreamNamePrefix: aws.String(t.Stream),
+		}
+		var streams *cloudwatchlogs.DescribeLogStreamsOutput
+		var err error
+		if withContext {
+			streams, err = cs.DescribeLogStreamsWithContext(ctx, input)
+		} else {
+			streams, err = describer.DescribeLogStreams(input)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to describe log stream %v/%v: %w", t.Group, t.Stream, err)
+		}
+		if !hasLogStream(streams, t.Stream) {
+			return fmt.Errorf("log stream %v/%v does not exist", t.Group, t.Stream)
+		}
+	}
+	return nil
+}
+
+// probePut checks from the send loop that log events can be put to the log group and stream.
+func (p *pusher) probePut(ctx context.Context, t Target) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("validation of %v/%v not completed: %w", t.Group, t.Stream, err)
+	}
+	input := &cloudwatchlogs.PutLogEventsInput{
+		LogGroupName:  aws.String(t.Group),
+		LogStreamName: aws.String(t.Stream),
+		LogEvents: []*cloudwatchlogs.InputLogEvent{{
+			Message:   aws.String("amazon-cloudwatch-agent validation probe"),
+			Timestamp: aws.Int64(time.Now().Add(-validationProbeAge).UnixNano() / 1000000),
+		}},
+		SequenceToken: p.sequenceToken,
+	}
+	var output *cloudwatchlogs.PutLogEventsOutput
+	var err error
+	if cs, ok := p.Service.(contextService); ok {
+		output, err = cs.PutLogEventsWithContext(ctx, input)
+	} else {
+		output, err = p.Service.PutLogEvents(input)
+	}
+	// The next send uses the sequence token the probe consumed
+	if err == nil && output != nil && output.NextSequenceToken != nil {
+		p.updateSequenceToken(output.NextSequenceToken)
+	}
+	if e, ok := err.(*cloudwatchlogs.InvalidSequenceTokenException); ok {
+		p.updateSequenceToken(e.ExpectedSequenceToken)
+	}
+	if awsErr, ok := err.(awserr.Error); ok {
+		switch awsErr.Code() {
+		// The request was authorized, only the sequence token was refused
+		case cloudwatchlogs.ErrCodeInvalidSequenceTokenException, cloudwatchlogs.ErrCodeDataAlreadyAcceptedException:
+			err = nil
+		}
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("validation of %v/%v not completed: %w", t.Group, t.Stream, ctx.Err())
+		}
+		return fmt.Errorf("unable to put log events to %v/%v: %w", t.Group, t.Stream, err)
+	}
+	return nil
+}
+
+func hasLogGroup(output *cloudwatchlogs.DescribeLogGroupsOutput, group string) bool {
+	if output == nil {
+		return false
+	}
+	for _, g := range output.LogGroups {
+		if g.LogGroupName != nil && *g.LogGroupName == group {
+			return true
+		}
+	}
+	return false
+}
+
+func hasLogStream(output *cloudwatchlogs.DescribeLogStreamsOutput, stream string) bool {
+	return findLogStream(output, stream) != nil
+}
+
+// findLogStream returns the stream named stream among the described ones, or nil.
+func findLogStream(output *cloudwatchlogs.DescribeLogStreamsOutput, stream string) *cloudwatchlogs.LogStream {
+	if output == nil {
+		return nil
+	}
+	for _, s := range output.LogStreams {
+		if s.LogStreamName != nil && *s.LogStreamName == stream {
+			return s
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"context"
+	"time"
+)
+
+// waitEmptyInterval is the time between two checks of WaitEmpty.
+const waitEmptyInterval = 50 * time.Millisecond
+
+// PendingEvents returns the number of events buffered and not delivered yet: the current batch, a held
+// partial event, the batches waiting for a retry and those held over the byte budget or while paused.
+// It is taken from the start loop, so it may wait for a send in progress. It is 0 once the pusher is stopped.
+func (p *pusher) PendingEvents() int {
+	n := 0
+	if err := p.controlWait(func() { n = p.pendingEvents() }); err != nil {
+		return 0
+	}
+	return n
+}
+
+func (p *pusher) pendingEvents() int {
+	n := len(p.events)
+	if p.partial != nil {
+		n++
+	}
+	for _, b := range p.retryQueue {
+		n += len(b.events)
+	}
+	for _, b := range p.budgetHeld {
+		n += len(b.events)
+	}
+	for _, b := range p.pausedBatches {
+		n += len(b.events)
+	}
+	return n
+}
+
+// WaitEmpty waits until the events added with AddEvent are all delivered, with no events buffered nor
+// queued, or until ctx is done or the pusher stopped. Unlike Close, it does not send anything sooner,
+// the batches are still sent on the flush timer. Each check runs in the start loop for an accurate snapshot.
+func (p *pusher) WaitEmpty(ctx context.Context) error {
+	for {
+		empty := make(chan bool, 1)
+		check := &controlEvent{func() { empty <- p.pendingEvents() == 0 && len(p.eventsCh) == 0 }}
+		select {
+		case p.eventsCh <- check:
+		case <-p.stop:
+			return errPusherStopped
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		select {
+		case e := <-empty:
+			if e {
+				return nil
+			}
+		case <-p.stop:
+			return errPusherStopped
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		t := time.NewTimer(waitEmptyInterval)
+		select {
+		case <-t.C:
+		case <-p.stop:
+			t.Stop()
+			return errPusherStopped
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,597 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/amazon-cloudwatch-agent/logs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/influxdata/telegraf"
+)
+
+const (
+	// A WAL record is its length and CRC-32 followed by the entry ID, timestamp, lengths of the log group
+	// and stream names, and the names and message
+	walRecordHeaderSize = 8
+	walEntryHeaderSize  = 20
+	walMaxTargetSize    = 2 * 512
+
+	defaultWALMaxSize = 64 * 1024 * 1024
+)
+
+var (
+	errWALFull   = errors.New("write-ahead log full")
+	errWALClosed = errors.New("write-ahead log file not open")
+)
+
+// WAL is a write-ahead log of the events added to a pusher, for at-least-once delivery across restarts.
+// Each event is appended to the file and synced before it is queued, and removed from the file once
+// delivered or dropped. The appends waiting for a sync at the same time share it. The file is compacted
+// in the background once half of it holds removed events. The events still in the file when it is opened
+// again are sent by ReplayWAL.
+type WAL struct {
+	// synced is the number of records appended and synced, or compacted into a synced file
+	synced uint64
+	// syncMu serializes the syncs, the appends waiting for it are covered by the next one
+	syncMu sync.Mutex
+
+	mu          sync.Mutex
+	path        string
+	log         telegraf.Logger
+	maxSize     int64
+	f           *os.File
+	size        int64 // size of the file
+	pending     map[uint64]walEntry
+	pendingSize int64 // size of the records of the pending entries
+	nextID      uint64
+	written     uint64 // number of records appended
+	compactCh   chan struct{}
+	closed      chan struct{}
+	wg          sync.WaitGroup
+}
+
+type walEntry struct {
+	id      uint64
+	t       time.Time
+	group   string // log group and stream the event was added for
+	stream  string
+	message string
+}
+
+func (e walEntry) recordSize() int64 {
+	return int64(walRecordHeaderSize + walEntryHeaderSize + len(e.group) + len(e.stream) + len(e.message))
+}
+
+// OpenWAL opens the write-ahead log at path, creating it if needed. The records of the file are
+// loaded as pending entries, up to the first one torn or corrupted by a crash, which is cut off.
+// The pending entries may take up to maxSize bytes, 64MB when it is 0.
+func OpenWAL(path string, maxSize int64, logger telegraf.Logger) (*WAL, error) {
+	if maxSize <= 0 {
+		maxSize = defaultWALMaxSize
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	w := &WAL{
+		path:      path,
+		log:       logger,
+		maxSize:   maxSize,
+		f:         f,
+		pending:   make(map[uint64]walEntry),
+		compactCh: make(chan struct{}, 1),
+		closed:    make(chan struct{}),
+	}
+	if err := w.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	w.wg.Add(1)
+	go w.compactLoop()
+	return w, nil
+}
+
+// load reads the records of the file and truncates it after the last valid one.
+func (w *WAL) load() error {
+	r := bufio.NewReader(w.f)
+	var offset int64
+	for {
+		e, n, err := readWALRecord(r)
+		if err != nil {
+			break
+		}
+		w.pending[e.id] = e
+		w.pendingSize += n
+		if e.id >= w.nextID {
+			w.nextID = e.id + 1
+		}
+		offset += n
+	}
+	if err := w.f.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err := w.f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	w.size = offset
+	return nil
+}
+
+// readWALRecord reads a record, returning an error at the end of the file or on an invalid record.
+func readWALRecord(r io.Reader) (walEntry, int64, error) {
+	var header [walRecordHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return walEntry{}, 0, err
+	}
+	length := binary.LittleEndian.Uint32(header[0:4])
+	if length < walEntryHeaderSize || length > walEntryHeaderSize+walMaxTargetSize+reqSizeLimit {
+		return walEntry{}, 0, fmt.Errorf("invalid write-ahead log record length %v", length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return walEntry{}, 0, err
+	}
+	if crc32.ChecksumIEEE(data) != binary.LittleEndian.Uint32(header[4:8]) {
+		return walEntry{}, 0, errors.New("invalid write-ahead log record checksum")
+	}
+	groupLen := int(binary.LittleEndian.Uint16(data[16:18]))
+	streamLen := int(binary.LittleEndian.Uint16(data[18:20]))
+	names := data[walEntryHeaderSize:]
+	if groupLen+streamLen > len(names) {
+		return walEntry{}, 0, errors.New("invalid write-ahead log record target")
+	}
+	e := walEntry{
+		id:      binary.LittleEndian.Uint64(data[0:8]),
+		group:   string(names[:groupLen]),
+		stream:  string(names[groupLen : groupLen+streamLen]),
+		message: string(names[groupLen+streamLen:]),
+	}
+	if t := int64(binary.LittleEndian.Uint64(data[8:16])); t != 0 {
+		e.t = time.Unix(0, t)
+	}
+	return e, int64(walRecordHeaderSize + length), nil
+}
+
+func appendWALRecord(buf []byte, e walEntry) []byte {
+	var t int64
+	if !e.t.IsZero() {
+		t = e.t.UnixNano()
+	}
+	data := make([]byte, walEntryHeaderSize, walEntryHeaderSize+len(e.group)+len(e.stream)+len(e.message))
+	binary.LittleEndian.PutUint64(data[0:8], e.id)
+	binary.LittleEndian.PutUint64(data[8:16], uint64(t))
+	binary.LittleEndian.PutUint16(data[16:18], uint16(len(e.group)))
+	binary.LittleEndian.PutUint16(data[18:20], uint16(len(e.stream)))
+	data = append(data, e.group...)
+	data = append(data, e.stream...)
+	data = append(data, e.message...)
+
+	var header [walRecordHeaderSize]byte
+	binary.LittleEndian.PutUint32(header[0:4], uint32(len(data)))
+	binary.LittleEndian.PutUint32(header[4:8], crc32.ChecksumIEEE(data))
+	buf = append(buf, header[:]...)
+	return append(buf, data...)
+}
+
+// Append writes an event added for the log group and stream to the log and syncs the file, and returns the ID
+// of its entry. A message longer than a request is cut to its size, as the pusher would send it. It fails with
+// errWALFull when the pending entries would exceed the maximum size.
+func (w *WAL) Append(group, stream string, t time.Time, message string) (uint64, error) {
+	if len(group)+len(stream) > walMaxTargetSize {
+		return 0, fmt.Errorf("invalid log group and stream names %v/%v", group, stream)
+	}
+	id, seq, err := w.write(walEntry{t: t, group: group, stream: stream, message: truncateUTF8(message, reqSizeLimit)})
+	if err != nil {
+		return 0, err
+	}
+	if err := w.syncTo(seq); err != nil {
+		w.Ack(id)
+		return 0, err
+	}
+	return id, nil
+}
+
+// write appends the record of an entry to the file without syncing it, and returns its ID and the number
+// of records written up to it.
+func (w *WAL) write(e walEntry) (uint64, uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	e.id = w.nextID
+	n := e.recordSize()
+	if w.pendingSize+n > w.maxSize {
+		return 0, 0, errWALFull
+	}
+	if w.size+n > w.maxSize {
+		if err := w.compact(); err != nil {
+			return 0, 0, err
+		}
+	}
+	if w.f == nil {
+		if err := w.reopen(w.size, nil); err != nil {
+			return 0, 0, err
+		}
+	}
+	if _, err := w.f.Write(appendWALRecord(nil, e)); err != nil {
+		// Cut a partial record so the following ones are not lost behind it on the next load
+		w.f.Truncate(w.size)
+		w.f.Seek(w.size, io.SeekStart)
+		return 0, 0, err
+	}
+	w.nextID++
+	w.written++
+	w.size += n
+	w.pending[e.id] = e
+	w.pendingSize += n
+	return e.id, w.written, nil
+}
+
+// syncTo syncs the file unless the first seq records are synced already, by a sync of another append
+// or a compaction.
+func (w *WAL) syncTo(seq uint64) error {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+	if atomic.LoadUint64(&w.synced) >= seq {
+		return nil
+	}
+	w.mu.Lock()
+	f, written := w.f, w.written
+	w.mu.Unlock()
+	if f == nil {
+		return errWALClosed
+	}
+	if err := f.Sync(); err != nil {
+		// The file may have been compacted meanwhile, into a synced file
+		if atomic.LoadUint64(&w.synced) >= seq {
+			return nil
+		}
+		return err
+	}
+	w.setSynced(written)
+	return nil
+}
+
+func (w *WAL) setSynced(n uint64) {
+	for {
+		synced := atomic.LoadUint64(&w.synced)
+		if synced >= n || atomic.CompareAndSwapUint64(&w.synced, synced, n) {
+			return
+		}
+	}
+}
+
+// truncateUTF8 cuts s to at most n bytes, on a rune boundary.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// Ack marks the entry delivered. It is removed from the file by the next compaction.
+func (w *WAL) Ack(id uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	e, ok := w.pending[id]
+	if !ok {
+		return
+	}
+	delete(w.pending, id)
+	w.pendingSize -= e.recordSize()
+	if w.size-w.pendingSize > w.size/2 {
+		select {
+		case w.compactCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// entries returns the pending entries in the order they were appended.
+func (w *WAL) entries() []walEntry {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	entries := make([]walEntry, 0, len(w.pending))
+	for _, e := range w.pending {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].id < entries[j].id })
+	return entries
+}
+
+func (w *WAL) compactLoop() {
+	defer w.wg.Done()
+	for {
+		select {
+		case <-w.compactCh:
+			w.mu.Lock()
+			if err := w.compact(); err != nil {
+				w.log.Errorf("Unable to compact the write-ahead log %v: %v", w.path, err)
+			}
+			w.mu.Unlock()
+		case <-w.closed:
+			return
+		}
+	}
+}
+
+// compact rewrites the file with the pending entries only. The new file is synced and closed before it
+// replaces the old one, which is closed as well so the rename also succeeds on Windows, then the directory
+// is synced, so a crash leaves either of them complete. It must be called with the lock held.
+func (w *WAL) compact() error {
+	if w.size == w.pendingSize {
+		return nil
+	}
+
+	var buf []byte
+	ids := make([]uint64, 0, len(w.pending))
+	for id := range w.pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		buf = appendWALRecord(buf, w.pending[id])
+	}
+
+	tmp := w.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if w.f != nil {
+		w.f.Close()
+	}
+	if err := os.Rename(tmp, w.path); err != nil {
+		return w.reopen(w.size, err)
+	}
+	if err := syncDir(filepath.Dir(w.path)); err != nil {
+		w.log.Warnf("Unable to sync the directory of the write-ahead log %v: %v", w.path, err)
+	}
+	// The pending entries written so far are all in the synced file
+	w.setSynced(w.written)
+	return w.reopen(int64(len(buf)), nil)
+}
+
+// reopen opens the file again after a compaction, at its end of size, and returns err, or the error
+// opening it.
+func (w *WAL) reopen(size int64, err error) error {
+	f, openErr := os.OpenFile(w.path, os.O_RDWR, 0600)
+	if openErr != nil {
+		// The next append opens it again
+		w.f = nil
+		w.size = size
+		return openErr
+	}
+	if _, seekErr := f.Seek(size, io.SeekStart); seekErr != nil {
+		f.Close()
+		w.f = nil
+		w.size = size
+		return seekErr
+	}
+	w.f = f
+	w.size = size
+	return err
+}
+
+// syncDir syncs the directory so a rename in it is durable. Directories cannot be synced on Windows,
+// where the rename is durable once it returns.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Close stops the background compaction, compacts the file and closes it. The pending entries stay in the file.
+func (w *WAL) Close() error {
+	close(w.closed)
+	w.wg.Wait()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.compact(); err != nil {
+		if w.f != nil {
+			w.f.Close()
+		}
+		return err
+	}
+	if w.f == nil {
+		return nil
+	}
+	return w.f.Close()
+}
+
+// walLogEvent is a log event replayed from the write-ahead log.
+type walLogEvent struct {
+	msg  string
+	t    time.Time
+	id   uint64
+	done func()
+}
+
+func (e *walLogEvent) Message() string { return e.msg }
+func (e *walLogEvent) Time() time.Time { return e.t }
+func (e *walLogEvent) Done()           { e.done() }
+func (e *walLogEvent) walID() uint64   { return e.id }
+
+// walLogEntry is implemented by the log events with an entry in the WAL.
+type walLogEntry interface {
+	walID() uint64
+}
+
+// walTrackedLogEvent is a log event written to the WAL by AddEvent.
+type walTrackedLogEvent struct {
+	trackedLogEvent
+	id uint64
+}
+
+func (e *walTrackedLogEvent) walID() uint64 { return e.id }
+
+// walTrackedPartialLogEvent keeps the partial events recognizable once written to the WAL.
+type walTrackedPartialLogEvent struct {
+	trackedPartialLogEvent
+	id uint64
+}
+
+func (e *walTrackedPartialLogEvent) walID() uint64 { return e.id }
+
+// walIDs returns the IDs of the WAL entries of an event, through the events the pusher makes of it.
+func walIDs(e logs.LogEvent) []uint64 {
+	switch e := e.(type) {
+	case walLogEntry:
+		return []uint64{e.walID()}
+	case *mergedLogEvent:
+		return e.walIDs
+	case *splitLogEvent:
+		return walIDs(e.source)
+	}
+	return nil
+}
+
+// trackWAL records the WAL entries of the event added to the batch as ce, so they are removed from the
+// WAL once it is delivered or dropped, whichever the path.
+func (p *pusher) trackWAL(ce *cloudwatchlogs.InputLogEvent, e logs.LogEvent) {
+	if p.WAL == nil {
+		return
+	}
+	if ids := walIDs(e); len(ids) > 0 {
+		if p.walEntries == nil {
+			p.walEntries = make(map[*cloudwatchlogs.InputLogEvent][]uint64)
+		}
+		p.walEntries[ce] = ids
+	}
+}
+
+// releaseWAL removes the WAL entries of the batched events once they are delivered or dropped.
+func (p *pusher) releaseWAL(events []*cloudwatchlogs.InputLogEvent) {
+	if len(p.walEntries) == 0 {
+		return
+	}
+	for _, ce := range events {
+		for _, id := range p.walEntries[ce] {
+			p.WAL.Ack(id)
+		}
+		delete(p.walEntries, ce)
+	}
+}
+
+// releaseDroppedWAL removes the WAL entries of an event dropped before it was batched.
+func (p *pusher) releaseDroppedWAL(e logs.LogEvent) {
+	if p.WAL == nil {
+		return
+	}
+	for _, id := range walIDs(e) {
+		p.WAL.Ack(id)
+	}
+}
+
+// ReplayWAL queues the events left in the WAL by a previous run, before new events are added, for the log
+// group and stream they were added for. The events out of the accepted time range are removed from the WAL,
+// and counted as dropped. It returns the number of events queued.
+func (p *pusher) ReplayWAL() int {
+	if p.WAL == nil {
+		return 0
+	}
+	w := p.WAL
+	n := 0
+	for _, entry := range w.entries() {
+		id := entry.id
+		e := &walLogEvent{msg: entry.message, t: entry.t, id: id, done: func() { w.Ack(id) }}
+		t := p.target()
+		t.Group, t.Stream = entry.group, entry.stream
+		if !p.validTime(e) {
+			p.Log.Warnf("The log entry replayed for (%v/%v) with timestamp (%v) is out of accepted time range. Discard the log entry.", t.Group, t.Stream, e.t)
+			p.counters.addDropped(1)
+			w.Ack(id)
+			continue
+		}
+		queued, ok := p.replayTo(t, e)
+		if !ok {
+			break
+		}
+		if queued {
+			n++
+		}
+	}
+	return n
+}
+
+// replayTo queues the replayed event for the pusher of its log group and stream: the pusher, one of its shards,
+// or the pusher of a routed log group and stream. It reports whether the event was queued, and false for ok once
+// the pusher stopped. An event for an invalid log group or stream is removed from the WAL.
+func (p *pusher) replayTo(t Target, e *walLogEvent) (queued, ok bool) {
+	if p.sharded() {
+		for _, s := range p.createShards() {
+			if s.target() == t {
+				ok = s.enqueue(e)
+				return ok, ok
+			}
+		}
+	} else if t == p.target() {
+		ok = p.enqueue(e)
+		return ok, ok
+	}
+	b := p.streamPusher(t)
+	if b == nil {
+		p.Log.Errorf("Invalid log group or stream name %v/%v for the log entry replayed. Discard the log entry.", t.Group, t.Stream)
+		p.counters.addDropped(1)
+		p.WAL.Ack(e.id)
+		return false, true
+	}
+	defer p.releaseStream(b)
+	ok = b.p.enqueue(e)
+	return ok, ok
+}
+
+// logToWAL appends the event to the WAL and returns it with a done callback removing it from the WAL.
+// The event is returned as is when it cannot be appended, without the delivery guarantee.
+func (p *pusher) logToWAL(e logs.LogEvent) logs.LogEvent {
+	t := p.target()
+	id, err := p.WAL.Append(t.Group, t.Stream, e.Time(), e.Message())
+	if err != nil {
+		p.Log.Warnf("Unable to write the log event for %v to the write-ahead log, sending it without: %v", p.targetName(), err)
+		return e
+	}
+	done := func() {
+		e.Done()
+		p.WAL.Ack(id)
+	}
+	if pe, ok := e.(PartialLogEvent); ok {
+		return &walTrackedPartialLogEvent{trackedPartialLogEvent{wrapPartial(pe), done}, id}
+	}
+	return &walTrackedLogEvent{trackedLogEvent{wrappedLogEvent{e}, done}, id}
+}
@@ -0,0 +1,322 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/amazon-cloudwatch-agent/logs"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/influxdata/telegraf/models"
+)
+
+func tempWALPath(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "wal")
+}
+
+func TestWALReopenKeepsPendingEntries(t *testing.T) {
+	path := tempWALPath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	logger := models.NewLogger("cloudwatchlogs", "test", "")
+
+	w, err := OpenWAL(path, 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Truncate(time.Millisecond)
+	var ids []uint64
+	for _, msg := range []string{"a", "b", "c"} {
+		id, err := w.Append("G", "S", now, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	w.Ack(ids[1])
+	w.Close()
+
+	w, err = OpenWAL(path, 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	entries := w.entries()
+	if len(entries) != 2 || entries[0].message != "a" || entries[1].message != "c" || !entries[0].t.Equal(now) {
+		t.Errorf("Only the entries not acked should be pending after reopening, got %v", entries)
+	}
+	if id, _ := w.Append("G", "S", now, "d"); id <= ids[2] {
+		t.Errorf("The IDs should keep increasing after reopening, got %v after %v", id, ids[2])
+	}
+}
+
+func TestWALCutsTornRecord(t *testing.T) {
+	path := tempWALPath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	logger := models.NewLogger("cloudwatchlogs", "test", "")
+
+	w, err := OpenWAL(path, 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Append("G", "S", time.Now(), "complete")
+	w.Append("G", "S", time.Now(), "torn")
+	w.Close()
+
+	// Simulate a crash in the middle of the last append
+	info, _ := os.Stat(path)
+	os.Truncate(path, info.Size()-2)
+
+	w, err = OpenWAL(path, 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Append("G", "S", time.Now(), "after")
+	w.Close()
+
+	w, err = OpenWAL(path, 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	entries := w.entries()
+	if len(entries) != 2 || entries[0].message != "complete" || entries[1].message != "after" {
+		t.Errorf("The torn record should be cut off without losing the following ones, got %v", entries)
+	}
+}
+
+func TestWALBoundedSize(t *testing.T) {
+	path := tempWALPath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	w, err := OpenWAL(path, 100, models.NewLogger("cloudwatchlogs", "test", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	id, err := w.Append("G", "S", time.Now(), string(make([]byte, 60)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Append("G", "S", time.Now(), string(make([]byte, 60))); err != errWALFull {
+		t.Errorf("Expecting errWALFull over the maximum size, got %v", err)
+	}
+	w.Ack(id)
+	if _, err := w.Append("G", "S", time.Now(), string(make([]byte, 60))); err != nil {
+		t.Errorf("The acked entries should be compacted to make room, got %v", err)
+	}
+	if info, _ := os.Stat(path); info.Size() > 100 {
+		t.Errorf("The file should not exceed the maximum size, got %v bytes", info.Size())
+	}
+}
+
+func TestPusherReplaysWAL(t *testing.T) {
+	path := tempWALPath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	logger := models.NewLogger("cloudwatchlogs", "test", "")
+
+	// A first run logs events and crashes before delivering them
+	w, err := OpenWAL(path, 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Append("G", "S", time.Now(), "first")
+	w.Append("G", "S", time.Now(), "second")
+	w.Close()
+
+	// The next run replays them, and they are removed from the WAL once delivered
+	w, err = OpenWAL(path, 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	var s svcMock
+	var mu sync.Mutex
+	var sent []string
+	s.ple = func(in *cloudwatchlogs.PutLogEventsInput) (*cloudwatchlogs.PutLogEventsOutput, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, e := range in.LogEvents {
+			sent = append(sent, *e.Message)
+		}
+		return &cloudwatchlogs.PutLogEventsOutput{NextSequenceToken: aws.String("token")}, nil
+	}
+	p := NewPusher(Target{Group: "G", Stream: "S"}, &s, 1*time.Hour, maxRetryTimeout, logger)
+	p.WAL = w
+	if n := p.ReplayWAL(); n != 2 {
+		t.Errorf("Expecting 2 events replayed, got %v", n)
+	}
+	p.AddEvent(evtMock{"third", time.Now(), nil})
+	if _, err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(sent) != 3 || sent[0] != "first" || sent[1] != "second" || sent[2] != "third" {
+		t.Errorf("The replayed events should be sent before the new ones, got %v", sent)
+	}
+	if entries := w.entries(); len(entries) != 0 {
+		t.Errorf("The delivered events should be removed from the WAL, got %v", entries)
+	}
+}
+
+func TestWALKeepsRecordsAfterOversizedMessage(t *testing.T) {
+	path := tempWALPath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	logger := models.NewLogger("cloudwatchlogs", "test", "")
+
+	w, err := OpenWAL(path, 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Append("G", "S", time.Now(), strings.Repeat("é", reqSizeLimit))
+	w.Append("G", "S", time.Now(), "after")
+	w.Close()
+
+	w, err = OpenWAL(path, 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	entries := w.entries()
+	if len(entries) != 2 || len(entries[0].message) > reqSizeLimit || !utf8.ValidString(entries[0].message) || entries[1].message != "after" {
+		t.Errorf("Expecting the oversized message cut to a request and the next one kept, got %v entries", len(entries))
+	}
+}
+
+func TestWALRemovesDroppedEvents(t *testing.T) {
+	path := tempWALPath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	logger := models.NewLogger("cloudwatchlogs", "test", "")
+
+	w, err := OpenWAL(path, 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	var s svcMock
+	s.ple = func(in *cloudwatchlogs.PutLogEventsInput) (*cloudwatchlogs.PutLogEventsOutput, error) {
+		return nil, awserr.New("AccessDeniedException", "denied", nil)
+	}
+	p := NewPusher(Target{Group: "G", Stream: "S"}, &s, 1*time.Hour, maxRetryTimeout, logger)
+	p.WAL = w
+	p.DedupTTL = time.Hour
+	p.DroppedEventDonePolicy = SkipDone
+	p.AddEvent(evtMock{"dead-lettered", time.Now(), nil})
+	p.AddEvent(evtMock{"dead-lettered", time.Now(), nil})
+	if _, err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if entries := w.entries(); len(entries) != 0 {
+		t.Errorf("Expecting the dead-lettered and duplicate events removed from the WAL, got %v", entries)
+	}
+}
+
+func TestPusherReplaysWALToTargets(t *testing.T) {
+	path := tempWALPath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	logger := models.NewLogger("cloudwatchlogs", "test", "")
+
+	// A first run logs events of its shards and routed streams, and crashes before delivering them
+	w, err := OpenWAL(path, 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Append("G", "S-0", time.Now(), "shard0")
+	w.Append("G", "S-1", time.Now(), "shard1")
+	w.Append("G", "OTHER", time.Now(), "routed")
+	w.Append("G2", "S", time.Now(), "other group")
+	w.Close()
+
+	w, err = OpenWAL(path, 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	var s svcMock
+	var mu sync.Mutex
+	sent := make(map[string][]string)
+	s.ple = func(in *cloudwatchlogs.PutLogEventsInput) (*cloudwatchlogs.PutLogEventsOutput, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, e := range in.LogEvents {
+			sent[*in.LogGroupName+"/"+*in.LogStreamName] = append(sent[*in.LogGroupName+"/"+*in.LogStreamName], *e.Message)
+		}
+		return &cloudwatchlogs.PutLogEventsOutput{}, nil
+	}
+	p := NewPusher(Target{Group: "G", Stream: "S"}, &s, 1*time.Hour, maxRetryTimeout, logger)
+	p.WAL = w
+	p.ShardCount = 2
+	p.ShardKeyFn = func(e logs.LogEvent) string {
+		t.Errorf("The replayed events should go to the shard they were logged by, not hashed again")
+		return ""
+	}
+	if n := p.ReplayWAL(); n != 4 {
+		t.Errorf("Expecting 4 events replayed, got %v", n)
+	}
+	if _, err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := map[string][]string{
+		"G/S-0":   {"shard0"},
+		"G/S-1":   {"shard1"},
+		"G/OTHER": {"routed"},
+		"G2/S":    {"other group"},
+	}
+	if fmt.Sprint(sent) != fmt.Sprint(expected) {
+		t.Errorf("Expecting the replayed events sent to their log group and stream, got %v", sent)
+	}
+	if entries := w.entries(); len(entries) != 0 {
+		t.Errorf("The delivered events should be removed from the WAL, got %v", entries)
+	}
+}
+
+func TestWALKeepsFlushMarkers(t *testing.T) {
+	path := tempWALPath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	logger := models.NewLogger("cloudwatchlogs", "test", "")
+
+	w, err := OpenWAL(path, 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	var s svcMock
+	sends := 0
+	s.ple = func(in *cloudwatchlogs.PutLogEventsInput) (*cloudwatchlogs.PutLogEventsOutput, error) {
+		sends++
+		return &cloudwatchlogs.PutLogEventsOutput{}, nil
+	}
+	p := NewPusher(Target{Group: "G", Stream: "S"}, &s, 1*time.Hour, maxRetryTimeout, logger)
+	defer p.Stop()
+	p.WAL = w
+	p.AddEvent(evtMock{"msg", time.Now(), nil})
+	p.AddEvent(markerEvtMock{evtMock{"marker", time.Now(), nil}})
+	p.controlWait(func() {})
+
+	if sends != 1 {
+		t.Errorf("Expecting the flush marker written to the WAL to send the batch, got %v sends", sends)
+	}
+	if entries := w.entries(); len(entries) != 0 {
+		t.Errorf("The delivered events should be removed from the WAL, got %v", entries)
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"time"
+
+	"github.com/aws/amazon-cloudwatch-agent/logs"
+)
+
+// wrappedLogEvent is embedded by the log events the pusher wraps the added events in, such as to track their
+// delivery, and forwards the optional interfaces of the wrapped event, their zero values being the ones of an
+// event without them. The wrappers only override the methods they change.
+type wrappedLogEvent struct {
+	logs.LogEvent
+}
+
+func (e wrappedLogEvent) IngestTime() time.Time {
+	if ie, ok := e.LogEvent.(IngestTimeLogEvent); ok {
+		return ie.IngestTime()
+	}
+	return time.Time{}
+}
+
+func (e wrappedLogEvent) FlushMarker() bool {
+	fm, ok := e.LogEvent.(FlushMarkerLogEvent)
+	return ok && fm.FlushMarker()
+}
+
+func (e wrappedLogEvent) StreamName() string {
+	if se, ok := e.LogEvent.(StreamLogEvent); ok {
+		return se.StreamName()
+	}
+	return ""
+}
+
+func (e wrappedLogEvent) Deadline() (time.Time, bool) { return eventDeadline(e.LogEvent) }
+
+// wrappedPartialLogEvent is the wrappedLogEvent of a partial event, which keeps it recognizable.
+type wrappedPartialLogEvent struct {
+	wrappedLogEvent
+}
+
+func (e wrappedPartialLogEvent) Partial() bool { return e.LogEvent.(PartialLogEvent).Partial() }
+
+func wrapPartial(e PartialLogEvent) wrappedPartialLogEvent {
+	return wrappedPartialLogEvent{wrappedLogEvent{e}}
+}
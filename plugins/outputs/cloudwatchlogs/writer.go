@@ -0,0 +1,34 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package cloudwatchlogs
+
+import (
+	"io"
+	"strings"
+	"time"
+)
+
+// Writer returns a writer adding each line written to it as a log event with the current time,
+// so the pusher can be used as the output of a standard logger, e.g. log.SetOutput(p.Writer()).
+// A write is expected to hold whole lines, a line split over several writes is sent as several events.
+// Empty lines are skipped. Writes never fail, the events are handled like those of AddEvent.
+func (p *pusher) Writer() io.Writer {
+	return &logWriter{p}
+}
+
+type logWriter struct {
+	p *pusher
+}
+
+func (w *logWriter) Write(b []byte) (int, error) {
+	now := time.Now()
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" {
+			continue
+		}
+		w.p.AddEvent(&structuredLogEvent{msg: line, t: now})
+	}
+	return len(b), nil
+}